@@ -0,0 +1,137 @@
+package sardine
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
+)
+
+// statisticAggregator aggregates metric data into statistic sets for each window.
+type statisticAggregator struct {
+	mu   sync.Mutex
+	aggs map[string]*statisticAggregate
+}
+
+type statisticAggregate struct {
+	namespace string
+	window    time.Duration
+	start     time.Time
+	// datum has the name, dimensions, unit and storage resolution of the metric, and the aggregated statistic set.
+	datum types.MetricDatum
+}
+
+func newStatisticAggregator() *statisticAggregator {
+	return &statisticAggregator{
+		aggs: make(map[string]*statisticAggregate),
+	}
+}
+
+// add aggregates metric data of the namespace into windows, and returns metric data of windows closed by newer data.
+func (a *statisticAggregator) add(ns string, mds []types.MetricDatum, window time.Duration) map[string][]types.MetricDatum {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	done := make(map[string][]types.MetricDatum)
+	for _, md := range mds {
+		key := aggregationKey(ns, md, window)
+		start := aws.ToTime(md.Timestamp).Truncate(window)
+		ag := a.aggs[key]
+		if ag != nil && start.After(ag.start) {
+			done[ns] = append(done[ns], ag.metricDatum())
+			ag = nil
+		}
+		// data older than the current window are aggregated into the current window.
+		if ag == nil {
+			ag = &statisticAggregate{
+				namespace: ns,
+				window:    window,
+				start:     start,
+				datum: types.MetricDatum{
+					MetricName:        md.MetricName,
+					Dimensions:        md.Dimensions,
+					Unit:              md.Unit,
+					StorageResolution: md.StorageResolution,
+					StatisticValues:   &types.StatisticSet{},
+				},
+			}
+			a.aggs[key] = ag
+		}
+		ag.add(aws.ToFloat64(md.Value))
+	}
+	return done
+}
+
+// expire returns metric data of windows which have ended until now.
+func (a *statisticAggregator) expire(now time.Time) map[string][]types.MetricDatum {
+	return a.collect(func(ag *statisticAggregate) bool {
+		return !ag.start.Add(ag.window).After(now)
+	})
+}
+
+// flush returns metric data of all windows.
+func (a *statisticAggregator) flush() map[string][]types.MetricDatum {
+	return a.collect(func(*statisticAggregate) bool {
+		return true
+	})
+}
+
+func (a *statisticAggregator) collect(fn func(*statisticAggregate) bool) map[string][]types.MetricDatum {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	done := make(map[string][]types.MetricDatum)
+	for _, key := range sortedKeys(a.aggs) {
+		ag := a.aggs[key]
+		if !fn(ag) {
+			continue
+		}
+		done[ag.namespace] = append(done[ag.namespace], ag.metricDatum())
+		delete(a.aggs, key)
+	}
+	return done
+}
+
+func (ag *statisticAggregate) add(v float64) {
+	ss := ag.datum.StatisticValues
+	if aws.ToFloat64(ss.SampleCount) == 0 {
+		ss.Minimum, ss.Maximum = aws.Float64(v), aws.Float64(v)
+	} else {
+		if v < aws.ToFloat64(ss.Minimum) {
+			ss.Minimum = aws.Float64(v)
+		}
+		if v > aws.ToFloat64(ss.Maximum) {
+			ss.Maximum = aws.Float64(v)
+		}
+	}
+	ss.SampleCount = aws.Float64(aws.ToFloat64(ss.SampleCount) + 1)
+	ss.Sum = aws.Float64(aws.ToFloat64(ss.Sum) + v)
+}
+
+// metricDatum returns the metric datum of the window. The timestamp is the start of the window.
+func (ag *statisticAggregate) metricDatum() types.MetricDatum {
+	md := ag.datum
+	md.Timestamp = aws.Time(ag.start)
+	return md
+}
+
+func aggregationKey(ns string, md types.MetricDatum, window time.Duration) string {
+	var b strings.Builder
+	b.WriteString(ns)
+	b.WriteByte(0)
+	b.WriteString(aws.ToString(md.MetricName))
+	for _, d := range md.Dimensions {
+		b.WriteByte(0)
+		b.WriteString(aws.ToString(d.Name))
+		b.WriteByte('=')
+		b.WriteString(aws.ToString(d.Value))
+	}
+	b.WriteByte(0)
+	b.WriteString(string(md.Unit))
+	b.WriteByte(0)
+	b.WriteString(strconv.Itoa(int(aws.ToInt32(md.StorageResolution))))
+	b.WriteByte(0)
+	b.WriteString(window.String())
+	return b.String()
+}
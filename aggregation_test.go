@@ -0,0 +1,48 @@
+package sardine
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
+)
+
+func TestStatisticAggregator(t *testing.T) {
+	base := time.Date(2017, 12, 1, 16, 5, 0, 0, time.UTC)
+	datum := func(sec int, v float64) types.MetricDatum {
+		m := &Metric{Name: "cmd_get", Value: v, Timestamp: base.Add(time.Duration(sec) * time.Second)}
+		return m.NewMetricDatum(nil)
+	}
+	a := newStatisticAggregator()
+	if done := a.add("memcached/cmd", []types.MetricDatum{datum(0, 3), datum(10, 1), datum(50, 5)}, time.Minute); len(done) != 0 {
+		t.Errorf("unexpected done %#v", done)
+	}
+	if done := a.expire(base.Add(59 * time.Second)); len(done) != 0 {
+		t.Errorf("window must not be expired yet %#v", done)
+	}
+
+	// newer data closes the window
+	done := a.add("memcached/cmd", []types.MetricDatum{datum(70, 10)}, time.Minute)
+	mds := done["memcached/cmd"]
+	if len(mds) != 1 {
+		t.Fatalf("unexpected done %#v", done)
+	}
+	md := mds[0]
+	if md.Value != nil || !aws.ToTime(md.Timestamp).Equal(base) {
+		t.Errorf("unexpected datum %#v", md)
+	}
+	st := md.StatisticValues
+	if aws.ToFloat64(st.SampleCount) != 3 || aws.ToFloat64(st.Sum) != 9 || aws.ToFloat64(st.Minimum) != 1 || aws.ToFloat64(st.Maximum) != 5 {
+		t.Errorf("unexpected statistics count=%g sum=%g min=%g max=%g",
+			aws.ToFloat64(st.SampleCount), aws.ToFloat64(st.Sum), aws.ToFloat64(st.Minimum), aws.ToFloat64(st.Maximum))
+	}
+
+	mds = a.expire(base.Add(2 * time.Minute))["memcached/cmd"]
+	if len(mds) != 1 || aws.ToFloat64(mds[0].StatisticValues.Sum) != 10 {
+		t.Errorf("unexpected expired %#v", mds)
+	}
+	if done := a.flush(); len(done) != 0 {
+		t.Errorf("aggregator must be empty %#v", done)
+	}
+}
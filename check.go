@@ -5,23 +5,77 @@ import (
 	"fmt"
 	"log"
 	"os/exec"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
 
 	"github.com/Songmu/timeout"
 	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
 	"github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
 )
 
 type CheckPlugin struct {
-	ID         string
-	Namespace  string
-	Command    []string
-	Timeout    time.Duration
-	Interval   time.Duration
-	Dimensions [][]types.Dimension
+	ID          string
+	Name        string
+	Namespace   string
+	Command     []string
+	Timeout     time.Duration
+	Interval    time.Duration
+	Dimensions  [][]types.Dimension
+	Destination string
+	Sink        Sink
+	// StorageResolution is a storage resolution of CloudWatch metrics. 1 (high resolution) or 60.
+	StorageResolution int32
+	// HostID is a host ID of Mackerel to report the results.
+	HostID string
+	// MaxCheckAttempts is a number of consecutive non-OK results to change the hard state.
+	MaxCheckAttempts int
+	// RetryInterval is an interval to execute the command while the state is soft.
+	RetryInterval time.Duration
+	// AllStates publishes metrics of all results and CheckStatus.
+	AllStates bool
+
+	// checker is a native check. When it is set, Command is not executed.
+	checker checker
+
+	state checkState
+}
+
+// checkState is a state of a check plugin.
+// A non-OK result after OK is a soft state until it continues MaxCheckAttempts times, and then it becomes the hard state.
+type checkState struct {
+	hard     CheckResult
+	attempts int
+	soft     bool
+}
+
+// update updates the state by the result, and returns the hard state.
+func (s *checkState) update(id string, res CheckResult, maxAttempts int) CheckResult {
+	prev := s.hard
+	switch {
+	case res == CheckOK:
+		s.attempts = 0
+		s.soft = false
+		s.hard = res
+	case s.hard != CheckOK:
+		// already in a non-OK hard state
+		s.attempts++
+		s.soft = false
+		s.hard = res
+	default:
+		s.attempts++
+		s.soft = s.attempts < maxAttempts
+		if s.soft {
+			log.Printf("[%s] soft state %s (attempt %d/%d)", id, res, s.attempts, maxAttempts)
+		} else {
+			s.hard = res
+		}
+	}
+	if s.hard != prev {
+		log.Printf("[%s] hard state changed %s -> %s", id, prev, s.hard)
+	}
+	return s.hard
 }
 
 //go:generate stringer -type CheckResult
@@ -34,6 +88,9 @@ const (
 	CheckUnknown
 )
 
+// NewMetricDatum returns a CloudWatch metric datum of the result.
+//
+// Deprecated: Results are sent to sinks as metrics of a Batch, and converted by the sinks. It is not used by sardine.
 func (r CheckResult) NewMetricDatum(ds []types.Dimension, ts time.Time) types.MetricDatum {
 	return types.MetricDatum{
 		MetricName: aws.String(r.String()),
@@ -43,55 +100,115 @@ func (r CheckResult) NewMetricDatum(ds []types.Dimension, ts time.Time) types.Me
 	}
 }
 
-func (cp *CheckPlugin) Run(ctx context.Context, wg *sync.WaitGroup, ch chan *cloudwatch.PutMetricDataInput) {
+func (cp *CheckPlugin) Run(ctx context.Context, wg *sync.WaitGroup) {
 	defer wg.Done()
-	ticker := time.NewTicker(cp.Interval)
+	timer := time.NewTimer(0)
+	defer timer.Stop()
 	log.Printf("[%s] starting", cp.ID)
 	for {
-		if err := cp.RunAtOnce(ctx, ch); err != nil {
-			log.Println(err)
-		}
 		select {
 		case <-ctx.Done():
 			return
-		case <-ticker.C:
-			continue
+		case <-timer.C:
+		}
+		if err := cp.RunAtOnce(ctx); err != nil {
+			log.Println(err)
+		}
+		if cp.state.soft {
+			timer.Reset(cp.RetryInterval)
+		} else {
+			timer.Reset(cp.Interval)
 		}
 	}
 }
 
-func (cp *CheckPlugin) RunAtOnce(ctx context.Context, ch chan *cloudwatch.PutMetricDataInput) error {
-	res, err := cp.Execute(ctx)
-	if err != nil {
-		return fmt.Errorf("[%s] %s %w", cp.ID, res, err)
+// RunAtOnce executes the check, and sends the result to the sink.
+// A failure of the execution (e.g. a timeout) is sent as CheckUnknown with the error as the message, and then returned.
+func (cp *CheckPlugin) RunAtOnce(ctx context.Context) error {
+	start := time.Now()
+	res, msg, execErr := cp.Execute(ctx)
+	elapsed := time.Since(start)
+	if execErr != nil {
+		res = CheckUnknown
+		if msg != "" {
+			msg = fmt.Sprintf("%s: %s", execErr, msg)
+		} else {
+			msg = execErr.Error()
+		}
 	}
+	res = cp.state.update(cp.ID, res, cp.MaxCheckAttempts)
 	now := time.Now()
-	md := make([]types.MetricDatum, 0, len(cp.Dimensions)+1)
-	for _, ds := range cp.Dimensions {
-		md = append(md, res.NewMetricDatum(ds, now))
-	}
-	// no dimension metric
-	md = append(md, res.NewMetricDatum(nil, now))
-
-	// split MetricDatum/20
-	for i := 0; i <= len(md)/maxMetricDatum; i++ {
-		first := i * maxMetricDatum
-		last := first + maxMetricDatum
-		if last > len(md) {
-			last = len(md)
-		}
-		if len(md[first:last]) == 0 {
-			break
+	err := cp.Sink.Accept(ctx, &Batch{
+		PluginID:          cp.ID,
+		Metrics:           cp.newMetrics(res, elapsed, now),
+		Dimensions:        cp.Dimensions,
+		StorageResolution: cp.StorageResolution,
+		Check: &CheckReport{
+			Name:      cp.Name,
+			Namespace: cp.Namespace,
+			Result:    res,
+			Message:   msg,
+			Timestamp: now,
+			HostID:    cp.HostID,
+			SoftState: cp.state.soft,
+			Duration:  elapsed,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("[%s] %w", cp.ID, err)
+	}
+	if execErr != nil {
+		return fmt.Errorf("[%s] %s %w", cp.ID, CheckUnknown, execErr)
+	}
+	return nil
+}
+
+// newMetrics returns metrics of the result.
+// By default, only the metric of the result is returned with value 1.
+// With AllStates, metrics of all results (1 for the result, 0 for others) and CheckStatus are returned.
+// CheckDuration is always returned in seconds.
+func (cp *CheckPlugin) newMetrics(res CheckResult, elapsed time.Duration, ts time.Time) []*Metric {
+	var metrics []*Metric
+	if cp.AllStates {
+		for _, r := range []CheckResult{CheckOK, CheckFailed, CheckWarning, CheckUnknown} {
+			var v float64
+			if r == res {
+				v = 1
+			}
+			metrics = append(metrics, &Metric{Namespace: cp.Namespace, Name: r.String(), Value: v, Timestamp: ts})
 		}
-		ch <- &cloudwatch.PutMetricDataInput{
-			Namespace:  aws.String(cp.Namespace),
-			MetricData: md[first:last],
+		metrics = append(metrics, &Metric{Namespace: cp.Namespace, Name: "CheckStatus", Value: float64(res), Timestamp: ts})
+	} else {
+		metrics = append(metrics, &Metric{Namespace: cp.Namespace, Name: res.String(), Value: 1, Timestamp: ts})
+	}
+	if cp.MaxCheckAttempts > 1 {
+		var soft float64
+		if cp.state.soft {
+			soft = 1
 		}
+		metrics = append(metrics, &Metric{Namespace: cp.Namespace, Name: "CheckSoftState", Value: soft, Timestamp: ts})
 	}
-	return nil
+	metrics = append(metrics, &Metric{
+		Namespace: cp.Namespace,
+		Name:      "CheckDuration",
+		Value:     elapsed.Seconds(),
+		Timestamp: ts,
+		Unit:      string(types.StandardUnitSeconds),
+	})
+	return metrics
 }
 
-func (cp *CheckPlugin) Execute(ctx context.Context) (CheckResult, error) {
+// Execute executes the command, and returns the result and the first line of the output as a message.
+func (cp *CheckPlugin) Execute(ctx context.Context) (CheckResult, string, error) {
+	if cp.checker != nil {
+		ctx, cancel := context.WithTimeout(ctx, cp.Timeout)
+		defer cancel()
+		res, msg := cp.checker.check(ctx)
+		if Debug || res != CheckOK {
+			log.Printf("[%s] %s", cp.ID, msg)
+		}
+		return res, msg, nil
+	}
 	tio := &timeout.Timeout{
 		Duration:  cp.Timeout,
 		KillAfter: 5 * time.Second,
@@ -105,20 +222,31 @@ func (cp *CheckPlugin) Execute(ctx context.Context) (CheckResult, error) {
 	if len(stderr) > 0 {
 		log.Printf("[%s] %s", cp.ID, stderr)
 	}
+	msg := checkMessage(stdout)
 	if status.IsTimedOut() || status.IsKilled() {
-		return CheckUnknown, fmt.Errorf("command execute timed out")
+		return CheckUnknown, msg, fmt.Errorf("command execute timed out")
 	}
 	if err != nil {
-		return CheckUnknown, fmt.Errorf("command execute failed: %w", err)
+		return CheckUnknown, msg, fmt.Errorf("command execute failed: %w", err)
 	}
 
 	st := status.GetExitCode()
 	switch st {
 	case 0:
-		return CheckOK, nil
+		return CheckOK, msg, nil
 	case int(CheckFailed), int(CheckWarning):
-		return CheckResult(st), err
+		return CheckResult(st), msg, err
 	default:
-		return CheckUnknown, fmt.Errorf("command execute failed with exit code %d", st)
+		return CheckUnknown, msg, fmt.Errorf("command execute failed with exit code %d", st)
+	}
+}
+
+// checkMessage returns the first non-empty line of the output.
+func checkMessage(stdout string) string {
+	for _, line := range strings.Split(stdout, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			return line
+		}
 	}
+	return ""
 }
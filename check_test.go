@@ -0,0 +1,153 @@
+package sardine
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckState(t *testing.T) {
+	tests := []struct {
+		result CheckResult
+		hard   CheckResult
+		soft   bool
+	}{
+		{CheckOK, CheckOK, false},
+		{CheckFailed, CheckOK, true},
+		{CheckOK, CheckOK, false},
+		{CheckFailed, CheckOK, true},
+		{CheckWarning, CheckOK, true},
+		{CheckFailed, CheckFailed, false},
+		{CheckWarning, CheckWarning, false},
+		{CheckOK, CheckOK, false},
+		{CheckUnknown, CheckOK, true},
+	}
+	var s checkState
+	for i, tt := range tests {
+		hard := s.update("test", tt.result, 3)
+		if hard != tt.hard || s.soft != tt.soft {
+			t.Errorf("[%d] unexpected state after %s expected:%s soft=%t got:%s soft=%t", i, tt.result, tt.hard, tt.soft, hard, s.soft)
+		}
+	}
+}
+
+func TestCheckStateSingleAttempt(t *testing.T) {
+	var s checkState
+	if hard := s.update("test", CheckFailed, 1); hard != CheckFailed || s.soft {
+		t.Errorf("unexpected state %s soft=%t", hard, s.soft)
+	}
+}
+
+func TestCheckMetrics(t *testing.T) {
+	ts := time.Unix(1512057958, 0)
+	tests := []struct {
+		plugin   *CheckPlugin
+		expected string
+	}{
+		{&CheckPlugin{MaxCheckAttempts: 1}, "CheckWarning=1,CheckDuration=1.5"},
+		{&CheckPlugin{MaxCheckAttempts: 1, AllStates: true}, "CheckOK=0,CheckFailed=0,CheckWarning=1,CheckUnknown=0,CheckStatus=2,CheckDuration=1.5"},
+		{&CheckPlugin{MaxCheckAttempts: 3, AllStates: true}, "CheckOK=0,CheckFailed=0,CheckWarning=1,CheckUnknown=0,CheckStatus=2,CheckSoftState=0,CheckDuration=1.5"},
+	}
+	for _, tt := range tests {
+		var got []string
+		for _, m := range tt.plugin.newMetrics(CheckWarning, 1500*time.Millisecond, ts) {
+			got = append(got, fmt.Sprintf("%s=%g", m.Name, m.Value))
+		}
+		if s := strings.Join(got, ","); s != tt.expected {
+			t.Errorf("unexpected metrics expected:%s got:%s", tt.expected, s)
+		}
+	}
+}
+
+func TestCheckMessage(t *testing.T) {
+	tests := map[string]string{
+		"":                                  "",
+		"OK: connected\nversion 1.6.9\n":    "OK: connected",
+		"\n  CRITICAL: refused  \ndetail\n": "CRITICAL: refused",
+	}
+	for stdout, expected := range tests {
+		if msg := checkMessage(stdout); msg != expected {
+			t.Errorf("unexpected message of %q expected:%q got:%q", stdout, expected, msg)
+		}
+	}
+}
+
+type batchSink struct {
+	nopSink
+	batches []*Batch
+}
+
+func (s *batchSink) Accept(ctx context.Context, b *Batch) error {
+	s.batches = append(s.batches, b)
+	return nil
+}
+
+func TestCheckAllStatesTimeout(t *testing.T) {
+	conf, err := parseConfig([]byte(`
+[plugin.check.timeout]
+command    = "sleep 10"
+timeout    = "100ms"
+namespace  = "test/check"
+all_states = true
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cp := conf.CheckPlugins["timeout"]
+	sink := &batchSink{}
+	cp.Sink = sink
+	if err := cp.RunAtOnce(context.Background()); err == nil {
+		t.Error("the timeout must be returned")
+	}
+	if len(sink.batches) != 1 {
+		t.Fatalf("unexpected batches %d", len(sink.batches))
+	}
+	var got []string
+	for _, m := range sink.batches[0].Metrics {
+		if m.Name == "CheckDuration" {
+			continue
+		}
+		got = append(got, fmt.Sprintf("%s=%g", m.Name, m.Value))
+	}
+	expected := "CheckOK=0,CheckFailed=0,CheckWarning=0,CheckUnknown=1,CheckStatus=3"
+	if s := strings.Join(got, ","); s != expected {
+		t.Errorf("unexpected metrics expected:%s got:%s", expected, s)
+	}
+}
+
+func TestCheckDurationTimeout(t *testing.T) {
+	conf, err := parseConfig([]byte(`
+[plugin.check.timeout]
+command   = "sleep 10"
+timeout   = "100ms"
+namespace = "test/check"
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cp := conf.CheckPlugins["timeout"]
+	sink := &batchSink{}
+	cp.Sink = sink
+	cp.RunAtOnce(context.Background())
+	if len(sink.batches) != 1 {
+		t.Fatalf("unexpected batches %d", len(sink.batches))
+	}
+	var found bool
+	for _, m := range sink.batches[0].Metrics {
+		if m.Name != "CheckDuration" {
+			continue
+		}
+		found = true
+		if m.Value < 0.1 || m.Unit != "Seconds" {
+			t.Errorf("unexpected duration %g %s", m.Value, m.Unit)
+		}
+	}
+	if !found {
+		t.Error("CheckDuration must be put on timeouts")
+	}
+	if c := sink.batches[0].Check; c.Duration < 100*time.Millisecond {
+		t.Errorf("unexpected duration of the report %s", c.Duration)
+	}
+}
@@ -0,0 +1,294 @@
+package sardine
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// maxCheckResponseSize is the max size of a response read by native checks.
+const maxCheckResponseSize = 1024 * 1024
+
+// checker is a native check which is executed without a command.
+// A failure of the target is returned as a result with a message, not as an error.
+type checker interface {
+	check(ctx context.Context) (CheckResult, string)
+}
+
+// newChecker returns a native checker of the type. It returns nil for the command type.
+func newChecker(pc *PluginConfig) (checker, error) {
+	typ := strings.ToLower(pc.Type)
+	if typ == "" || typ == "command" {
+		return nil, nil
+	}
+	if pc.Command != "" {
+		return nil, fmt.Errorf("command is not allowed for type %s", typ)
+	}
+	var expect *regexp.Regexp
+	if pc.Expect != "" {
+		var err error
+		if expect, err = regexp.Compile(pc.Expect); err != nil {
+			return nil, fmt.Errorf("invalid expect %s: %w", pc.Expect, err)
+		}
+	}
+	switch typ {
+	case "tcp":
+		if pc.Address == "" {
+			return nil, fmt.Errorf("address required for type tcp")
+		}
+		return &tcpChecker{address: pc.Address, send: pc.Send, expect: expect}, nil
+	case "http":
+		if pc.URL == "" {
+			return nil, fmt.Errorf("url required for type http")
+		}
+		return &httpChecker{
+			url:          pc.URL,
+			status:       pc.Status,
+			expect:       expect,
+			certWarning:  pc.CertExpiryWarning.Duration,
+			certCritical: pc.CertExpiryCritical.Duration,
+			client: &http.Client{
+				Transport: &http.Transport{
+					Proxy:           http.ProxyFromEnvironment,
+					TLSClientConfig: &tls.Config{InsecureSkipVerify: pc.Insecure},
+				},
+			},
+		}, nil
+	case "dns":
+		if pc.Host == "" {
+			return nil, fmt.Errorf("host required for type dns")
+		}
+		c := &dnsChecker{host: pc.Host, expect: expect, resolver: net.DefaultResolver}
+		if pc.Server != "" {
+			server := pc.Server
+			if _, _, err := net.SplitHostPort(server); err != nil {
+				server = net.JoinHostPort(server, "53")
+			}
+			c.resolver = &net.Resolver{
+				PreferGo: true,
+				Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+					var d net.Dialer
+					return d.DialContext(ctx, network, server)
+				},
+			}
+		}
+		return c, nil
+	case "process":
+		if pc.Process == "" && pc.Pidfile == "" {
+			return nil, fmt.Errorf("process or pidfile required for type process")
+		}
+		return &processChecker{name: pc.Process, pidfile: pc.Pidfile}, nil
+	default:
+		return nil, fmt.Errorf("type %s is not supported. use command, tcp, http, dns or process", pc.Type)
+	}
+}
+
+// tcpChecker connects to the address. It optionally sends a string and expects a response.
+type tcpChecker struct {
+	address string
+	send    string
+	expect  *regexp.Regexp
+}
+
+func (c *tcpChecker) check(ctx context.Context) (CheckResult, string) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", c.address)
+	if err != nil {
+		return CheckFailed, fmt.Sprintf("failed to connect to %s: %s", c.address, err)
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	if c.send != "" {
+		if _, err := io.WriteString(conn, c.send); err != nil {
+			return CheckFailed, fmt.Sprintf("failed to send to %s: %s", c.address, err)
+		}
+	}
+	if c.expect == nil {
+		return CheckOK, fmt.Sprintf("connected to %s", c.address)
+	}
+	// read until the response matches, or the connection is closed or timed out.
+	var res []byte
+	buf := make([]byte, 4096)
+	for len(res) < maxCheckResponseSize {
+		n, err := conn.Read(buf)
+		res = append(res, buf[:n]...)
+		if c.expect.Match(res) {
+			return CheckOK, fmt.Sprintf("%s responded %q", c.address, checkMessage(string(res)))
+		}
+		if err != nil {
+			break
+		}
+	}
+	return CheckFailed, fmt.Sprintf("unexpected response from %s: %q", c.address, checkMessage(string(res)))
+}
+
+// httpChecker requests the URL, and checks the status code, the body and the expiry of the TLS certificate.
+type httpChecker struct {
+	url          string
+	status       []int
+	expect       *regexp.Regexp
+	certWarning  time.Duration
+	certCritical time.Duration
+	client       *http.Client
+}
+
+func (c *httpChecker) check(ctx context.Context) (CheckResult, string) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url, nil)
+	if err != nil {
+		return CheckUnknown, err.Error()
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return CheckFailed, fmt.Sprintf("failed to request %s: %s", c.url, err)
+	}
+	defer resp.Body.Close()
+	if !c.expectedStatus(resp.StatusCode) {
+		return CheckFailed, fmt.Sprintf("unexpected status %s from %s", resp.Status, c.url)
+	}
+	if c.expect != nil {
+		body, err := io.ReadAll(io.LimitReader(resp.Body, maxCheckResponseSize))
+		if err != nil {
+			return CheckFailed, fmt.Sprintf("failed to read response from %s: %s", c.url, err)
+		}
+		if !c.expect.Match(body) {
+			return CheckFailed, fmt.Sprintf("response from %s does not match %s", c.url, c.expect)
+		}
+	}
+	if resp.TLS != nil && len(resp.TLS.PeerCertificates) > 0 {
+		notAfter := resp.TLS.PeerCertificates[0].NotAfter
+		remaining := time.Until(notAfter)
+		msg := fmt.Sprintf("certificate of %s expires in %s at %s", c.url, remaining.Truncate(time.Hour), notAfter.Format(time.RFC3339))
+		switch {
+		case c.certCritical > 0 && remaining < c.certCritical:
+			return CheckFailed, msg
+		case c.certWarning > 0 && remaining < c.certWarning:
+			return CheckWarning, msg
+		}
+	}
+	return CheckOK, fmt.Sprintf("%s responded %s", c.url, resp.Status)
+}
+
+// expectedStatus reports whether the status code is expected. By default, status codes less than 400 are expected.
+func (c *httpChecker) expectedStatus(code int) bool {
+	if len(c.status) == 0 {
+		return code < 400
+	}
+	for _, s := range c.status {
+		if s == code {
+			return true
+		}
+	}
+	return false
+}
+
+// dnsChecker resolves the host, and optionally expects an address.
+type dnsChecker struct {
+	host     string
+	expect   *regexp.Regexp
+	resolver *net.Resolver
+}
+
+func (c *dnsChecker) check(ctx context.Context) (CheckResult, string) {
+	addrs, err := c.resolver.LookupHost(ctx, c.host)
+	if err != nil {
+		return CheckFailed, fmt.Sprintf("failed to resolve %s: %s", c.host, err)
+	}
+	if c.expect != nil {
+		matched := false
+		for _, addr := range addrs {
+			if c.expect.MatchString(addr) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return CheckFailed, fmt.Sprintf("%s resolved to unexpected addresses %s", c.host, strings.Join(addrs, ","))
+		}
+	}
+	return CheckOK, fmt.Sprintf("%s resolved to %s", c.host, strings.Join(addrs, ","))
+}
+
+// processChecker checks that the process of the pidfile is running, or processes of the name are running.
+// Names are matched with the processes in /proc, so the name is supported only on Linux.
+type processChecker struct {
+	name    string
+	pidfile string
+}
+
+func (c *processChecker) check(ctx context.Context) (CheckResult, string) {
+	if c.pidfile != "" {
+		return c.checkPidfile()
+	}
+	dirs, err := filepath.Glob("/proc/[0-9]*")
+	if err != nil || len(dirs) == 0 {
+		return CheckUnknown, "failed to list processes in /proc"
+	}
+	var found int
+	for _, dir := range dirs {
+		ok, err := processHasName(dir, c.name)
+		if err != nil {
+			// the process exited
+			continue
+		}
+		if ok {
+			found++
+		}
+	}
+	if found == 0 {
+		return CheckFailed, fmt.Sprintf("process %s is not running", c.name)
+	}
+	return CheckOK, fmt.Sprintf("%d processes of %s are running", found, c.name)
+}
+
+func (c *processChecker) checkPidfile() (CheckResult, string) {
+	b, err := os.ReadFile(c.pidfile)
+	if err != nil {
+		return CheckFailed, fmt.Sprintf("failed to read pidfile: %s", err)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil || pid <= 0 {
+		return CheckFailed, fmt.Sprintf("invalid pid in %s", c.pidfile)
+	}
+	// signal 0 checks the existence of the process. EPERM means that the process exists.
+	if err := syscall.Kill(pid, 0); err != nil && !errors.Is(err, syscall.EPERM) {
+		return CheckFailed, fmt.Sprintf("process %d of %s is not running", pid, c.pidfile)
+	}
+	if c.name != "" {
+		ok, err := processHasName(fmt.Sprintf("/proc/%d", pid), c.name)
+		if err == nil && !ok {
+			return CheckFailed, fmt.Sprintf("process %d of %s is not %s", pid, c.pidfile, c.name)
+		}
+	}
+	return CheckOK, fmt.Sprintf("process %d of %s is running", pid, c.pidfile)
+}
+
+// processHasName reports whether the process of the /proc directory has the name.
+// The name is compared with the base name of argv[0] in cmdline, and the command name in comm.
+// comm is truncated to 15 bytes by the kernel, and argv[0] may be rewritten by the process (e.g. "nginx: master process").
+func processHasName(dir, name string) (bool, error) {
+	cmdline, err := os.ReadFile(filepath.Join(dir, "cmdline"))
+	if err != nil {
+		return false, err
+	}
+	if argv0, _, _ := strings.Cut(string(cmdline), "\x00"); argv0 != "" && filepath.Base(argv0) == name {
+		return true, nil
+	}
+	comm, err := os.ReadFile(filepath.Join(dir, "comm"))
+	if err != nil {
+		return false, err
+	}
+	return strings.TrimSpace(string(comm)) == name, nil
+}
@@ -0,0 +1,215 @@
+package sardine
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNativeChecks(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				if line, err := bufio.NewReader(conn).ReadString('\n'); err == nil {
+					fmt.Fprintf(conn, "VERSION 1.6.9 %s", line)
+				}
+			}()
+		}
+	}()
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/down" {
+			http.Error(w, "down", http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "status: ok")
+	})
+	hs := httptest.NewServer(h)
+	defer hs.Close()
+	tlss := httptest.NewTLSServer(h)
+	defer tlss.Close()
+
+	pidfile := filepath.Join(t.TempDir(), "sardine.pid")
+	if err := os.WriteFile(pidfile, []byte(fmt.Sprintf("%d\n", os.Getpid())), 0644); err != nil {
+		t.Fatal(err)
+	}
+	deadPidfile := filepath.Join(t.TempDir(), "dead.pid")
+	if err := os.WriteFile(deadPidfile, []byte("999999999\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	conf, err := parseConfig([]byte(fmt.Sprintf(`
+[plugin.check.tcp]
+namespace = "test"
+type      = "tcp"
+address   = "%[1]s"
+
+[plugin.check.tcp_expect]
+namespace = "test"
+type      = "tcp"
+address   = "%[1]s"
+send      = "version\n"
+expect    = "^VERSION "
+
+[plugin.check.tcp_unexpected]
+namespace = "test"
+type      = "tcp"
+address   = "%[1]s"
+send      = "version\n"
+expect    = "^ERROR"
+timeout   = "1s"
+
+[plugin.check.http]
+namespace = "test"
+type      = "http"
+url       = "%[2]s/"
+expect    = "status: ok"
+
+[plugin.check.http_down]
+namespace = "test"
+type      = "http"
+url       = "%[2]s/down"
+
+[plugin.check.http_status]
+namespace = "test"
+type      = "http"
+url       = "%[2]s/down"
+status    = [503]
+
+[plugin.check.https_expiry]
+namespace           = "test"
+type                = "http"
+url                 = "%[3]s/"
+insecure            = true
+cert_expiry_warning = "2000000h"
+
+[plugin.check.dns]
+namespace = "test"
+type      = "dns"
+host      = "localhost"
+
+[plugin.check.pidfile]
+namespace = "test"
+type      = "process"
+pidfile   = "%[4]s"
+
+[plugin.check.pidfile_dead]
+namespace = "test"
+type      = "process"
+pidfile   = "%[5]s"
+
+[plugin.check.process_not_found]
+namespace = "test"
+type      = "process"
+process   = "sardine-process-not-found"
+`, ln.Addr(), hs.URL, tlss.URL, pidfile, deadPidfile)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := map[string]CheckResult{
+		"tcp":               CheckOK,
+		"tcp_expect":        CheckOK,
+		"tcp_unexpected":    CheckFailed,
+		"http":              CheckOK,
+		"http_down":         CheckFailed,
+		"http_status":       CheckOK,
+		"https_expiry":      CheckWarning,
+		"dns":               CheckOK,
+		"pidfile":           CheckOK,
+		"pidfile_dead":      CheckFailed,
+		"process_not_found": CheckFailed,
+	}
+	for name, expected := range tests {
+		cp := conf.CheckPlugins[name]
+		res, msg, err := cp.Execute(context.Background())
+		if err != nil {
+			t.Errorf("[%s] unexpected error %s", name, err)
+		}
+		if res != expected {
+			t.Errorf("[%s] unexpected result expected:%s got:%s %s", name, expected, res, msg)
+		}
+	}
+}
+
+func TestNativeCheckConfig(t *testing.T) {
+	tests := map[string]string{
+		`type = "ping"`:    "type ping is not supported. use command, tcp, http, dns or process",
+		`type = "tcp"`:     "address required for type tcp",
+		`type = "http"`:    "url required for type http",
+		`type = "dns"`:     "host required for type dns",
+		`type = "process"`: "process or pidfile required for type process",
+		`type = "tcp"` + "\n" + `command = "true"`:                                   "command is not allowed for type tcp",
+		`type = "http"` + "\n" + `url = "http://localhost/"` + "\n" + `expect = "("`: "invalid expect (: error parsing regexp: missing closing ): `(`",
+	}
+	for options, expected := range tests {
+		_, err := parseConfig([]byte("[plugin.check.test]\nnamespace = \"test\"\n" + options + "\n"))
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("unexpected error for %q expected:%s got:%v", options, expected, err)
+		}
+	}
+	if _, err := parseConfig([]byte("[plugin.check.test]\nnamespace = \"test\"\ntype = \"command\"\ncommand = \"true\"\ntimeout = \"" + time.Second.String() + "\"\n")); err != nil {
+		t.Errorf("unexpected error for command type: %s", err)
+	}
+}
+
+func TestProcessCheckLongName(t *testing.T) {
+	sleep, err := exec.LookPath("sleep")
+	if err != nil {
+		t.Skip("sleep is not found")
+	}
+	b, err := os.ReadFile(sleep)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// longer than 15 bytes of comm
+	name := "sardine-long-process-name"
+	bin := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(bin, b, 0755); err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command(bin, "10")
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	}()
+	pidfile := filepath.Join(t.TempDir(), "long.pid")
+	if err := os.WriteFile(pidfile, []byte(fmt.Sprintf("%d\n", cmd.Process.Pid)), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		checker  *processChecker
+		expected CheckResult
+	}{
+		{&processChecker{name: name}, CheckOK},
+		{&processChecker{name: name, pidfile: pidfile}, CheckOK},
+		{&processChecker{name: name[:15]}, CheckOK}, // comm
+		{&processChecker{name: name + "-x", pidfile: pidfile}, CheckFailed},
+	}
+	for _, tt := range tests {
+		if res, msg := tt.checker.check(context.Background()); res != tt.expected {
+			t.Errorf("unexpected result of %s expected:%s got:%s %s", tt.checker.name, tt.expected, res, msg)
+		}
+	}
+}
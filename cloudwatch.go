@@ -0,0 +1,312 @@
+package sardine
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsConfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
+)
+
+var DefaultCloudWatchFlushInterval = 10 * time.Second
+
+var (
+	// limits of a PutMetricData request
+	maxMetricDatum       = 1000
+	maxPutMetricDataSize = 1000 * 1000
+)
+
+// CloudWatchConfig represents a config of the destination cloudwatch.
+type CloudWatchConfig struct {
+	FlushInterval duration `toml:"flush_interval"`
+}
+
+func (cc *CloudWatchConfig) setDefaults() {
+	if cc.FlushInterval.Duration == 0 {
+		cc.FlushInterval.Duration = DefaultCloudWatchFlushInterval
+	}
+}
+
+// cloudWatchSink puts metrics to CloudWatch.
+// Metrics of all plugins are coalesced by namespace within the flush interval, to reduce PutMetricData requests.
+type cloudWatchSink struct {
+	q     *queue
+	buf   *putMetricDataBuffer
+	agg   *statisticAggregator
+	svc   *cloudwatch.Client
+	retry *RetryConfig
+	spool *spool
+	// cancel stops flushPeriodically.
+	cancel  context.CancelFunc
+	stopped chan struct{}
+	// w is an output of dry run.
+	w io.Writer
+}
+
+func newCloudWatchSink(ctx context.Context, c *Config) (Sink, error) {
+	fctx, cancel := context.WithCancel(context.Background())
+	s := &cloudWatchSink{
+		q:       newQueue("cloudwatch", 1000),
+		buf:     newPutMetricDataBuffer(),
+		agg:     newStatisticAggregator(),
+		retry:   &c.Retry,
+		cancel:  cancel,
+		stopped: make(chan struct{}),
+	}
+	go s.flushPeriodically(fctx, c.CloudWatch.FlushInterval.Duration)
+	if DryRun {
+		s.w = os.Stdout
+		go s.q.run(s.send, 0, nil)
+		return s, nil
+	}
+
+	region := os.Getenv("AWS_REGION")
+	awscfg, err := awsConfig.LoadDefaultConfig(ctx, awsConfig.WithRegion(region))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load aws config: %w", err)
+	}
+	// retries are done by RetryConfig, not to multiply attempts by the retryer of the SDK.
+	s.svc = cloudwatch.NewFromConfig(awscfg, func(o *cloudwatch.Options) {
+		o.Retryer = aws.NopRetryer{}
+	})
+	if s.spool, err = newSpool(&c.Spool, "cloudwatch"); err != nil {
+		return nil, err
+	}
+	if s.spool != nil {
+		go s.q.run(s.send, s.spool.replayInterval, s.replay)
+	} else {
+		go s.q.run(s.send, 0, nil)
+	}
+	return s, nil
+}
+
+func (s *cloudWatchSink) Accept(ctx context.Context, b *Batch) error {
+	mds := newMetricData(b)
+	if b.AggregationWindow > 0 {
+		for _, ns := range sortedKeys(mds) {
+			if err := s.put(ctx, s.buf.addAll(s.agg.add(ns, mds[ns], b.AggregationWindow))); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for _, ns := range sortedKeys(mds) {
+		if err := s.put(ctx, s.buf.add(ns, mds[ns])); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *cloudWatchSink) Flush(ctx context.Context) error {
+	if err := s.put(ctx, s.buf.flush()); err != nil {
+		return err
+	}
+	return s.q.flush(ctx)
+}
+
+func (s *cloudWatchSink) Close(ctx context.Context) error {
+	// flushPeriodically may be blocked by the full queue. cancel it not to wait for sending.
+	s.cancel()
+	select {
+	case <-s.stopped:
+	case <-ctx.Done():
+		return fmt.Errorf("[cloudwatch] failed to stop flushing: %w", ctx.Err())
+	}
+	ins := append(s.buf.addAll(s.agg.flush()), s.buf.flush()...)
+	if err := s.put(ctx, ins); err != nil {
+		log.Printf("[cloudwatch] failed to flush buffered metrics: %s", err)
+	}
+	return s.q.close(ctx)
+}
+
+func (s *cloudWatchSink) put(ctx context.Context, ins []*cloudwatch.PutMetricDataInput) error {
+	for _, in := range ins {
+		if err := s.q.put(ctx, in); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// flushPeriodically puts buffered metrics into the queue every interval until ctx is cancelled by Close.
+func (s *cloudWatchSink) flushPeriodically(ctx context.Context, interval time.Duration) {
+	defer close(s.stopped)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			ins := append(s.buf.addAll(s.agg.expire(time.Now())), s.buf.flush()...)
+			if err := s.put(ctx, ins); err != nil {
+				log.Printf("[cloudwatch] failed to flush buffered metrics: %s", err)
+			}
+		}
+	}
+}
+
+// newMetricData converts a batch to metric data for each namespace.
+// Each metric is put with each dimension set and without dimensions.
+// Metrics without namespaces or with non-finite values are dropped.
+func newMetricData(b *Batch) map[string][]types.MetricDatum {
+	mds := make(map[string][]types.MetricDatum)
+	for _, metric := range b.Metrics {
+		ns := metric.Namespace
+		if ns == "" {
+			log.Printf("[%s] invalid metric name for cloudwatch: %s", b.PluginID, metric.Name)
+			continue
+		}
+		if math.IsNaN(metric.Value) || math.IsInf(metric.Value, 0) {
+			// CloudWatch rejects the whole request which has a non-finite value.
+			log.Printf("[%s] invalid metric value for cloudwatch: %s %g", b.PluginID, dottedMetricName(ns, metric.Name), metric.Value)
+			continue
+		}
+		for _, ds := range b.Dimensions {
+			mds[ns] = append(mds[ns], metric.NewMetricDatum(ds))
+		}
+		// no dimension metric
+		mds[ns] = append(mds[ns], metric.NewMetricDatum(nil))
+	}
+	if b.StorageResolution != 0 {
+		for _, data := range mds {
+			for i := range data {
+				data[i].StorageResolution = aws.Int32(b.StorageResolution)
+			}
+		}
+	}
+	return mds
+}
+
+// putMetricDataBuffer coalesces metric data by namespace into PutMetricData inputs within the limits of a request.
+type putMetricDataBuffer struct {
+	mu   sync.Mutex
+	data map[string][]types.MetricDatum
+	size map[string]int
+}
+
+func newPutMetricDataBuffer() *putMetricDataBuffer {
+	return &putMetricDataBuffer{
+		data: make(map[string][]types.MetricDatum),
+		size: make(map[string]int),
+	}
+}
+
+// add adds metric data of the namespace, and returns inputs which reached the limits.
+func (b *putMetricDataBuffer) add(ns string, mds []types.MetricDatum) []*cloudwatch.PutMetricDataInput {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var ins []*cloudwatch.PutMetricDataInput
+	for _, md := range mds {
+		size := metricDatumSize(md)
+		if len(b.data[ns]) > 0 && b.size[ns]+size > maxPutMetricDataSize-len(ns) {
+			ins = append(ins, b.cut(ns))
+		}
+		b.data[ns] = append(b.data[ns], md)
+		b.size[ns] += size
+		if len(b.data[ns]) >= maxMetricDatum {
+			ins = append(ins, b.cut(ns))
+		}
+	}
+	return ins
+}
+
+// addAll adds metric data of all namespaces, and returns inputs which reached the limits.
+func (b *putMetricDataBuffer) addAll(mds map[string][]types.MetricDatum) []*cloudwatch.PutMetricDataInput {
+	var ins []*cloudwatch.PutMetricDataInput
+	for _, ns := range sortedKeys(mds) {
+		ins = append(ins, b.add(ns, mds[ns])...)
+	}
+	return ins
+}
+
+// flush returns inputs of all buffered metric data.
+func (b *putMetricDataBuffer) flush() []*cloudwatch.PutMetricDataInput {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var ins []*cloudwatch.PutMetricDataInput
+	for _, ns := range sortedKeys(b.data) {
+		ins = append(ins, b.cut(ns))
+	}
+	return ins
+}
+
+func (b *putMetricDataBuffer) cut(ns string) *cloudwatch.PutMetricDataInput {
+	in := &cloudwatch.PutMetricDataInput{
+		Namespace:  aws.String(ns),
+		MetricData: b.data[ns],
+	}
+	delete(b.data, ns)
+	delete(b.size, ns)
+	return in
+}
+
+// metricDatumSize estimates the size of a metric datum encoded in a PutMetricData request.
+// e.g. MetricData.member.1000.MetricName=cmd_get&MetricData.member.1000.Value=10&...
+func metricDatumSize(md types.MetricDatum) int {
+	size := 160 + len(url.QueryEscape(aws.ToString(md.MetricName)))
+	if md.StorageResolution != nil {
+		size += 50
+	}
+	if md.Unit != "" {
+		size += 30 + len(md.Unit)
+	}
+	if md.StatisticValues != nil {
+		size += 320
+	}
+	for _, d := range md.Dimensions {
+		size += 110 + len(url.QueryEscape(aws.ToString(d.Name))) + len(url.QueryEscape(aws.ToString(d.Value)))
+	}
+	return size
+}
+
+// send puts in to CloudWatch. After ctx is cancelled, in is spooled or dropped.
+// It returns false when in is neither delivered nor spooled.
+func (s *cloudWatchSink) send(ctx context.Context, v interface{}) bool {
+	in := v.(*cloudwatch.PutMetricDataInput)
+	if s.w != nil {
+		printCloudWatch(s.w, in)
+		return true
+	}
+	if ctx.Err() != nil {
+		return spoolOrDrop(s.spool, in)
+	}
+	if Debug {
+		b, _ := json.Marshal(in)
+		log.Printf("putToCloudWatch: %s", b)
+	}
+	err := s.retry.Do(ctx, "PutMetricData to CloudWatch", func(ctx context.Context) error {
+		_, err := s.svc.PutMetricData(ctx, in)
+		return err
+	})
+	if err != nil {
+		log.Println("PutMetricData to CloudWatch failed:", err)
+		if isRetryableError(err) || ctx.Err() != nil {
+			return spoolOrDrop(s.spool, in)
+		}
+		return false
+	}
+	return true
+}
+
+func (s *cloudWatchSink) replay(ctx context.Context) {
+	s.spool.Replay(ctx, func(b []byte) error {
+		var in cloudwatch.PutMetricDataInput
+		if err := json.Unmarshal(b, &in); err != nil {
+			return &permanentError{err}
+		}
+		_, err := s.svc.PutMetricData(ctx, &in)
+		return err
+	})
+}
@@ -0,0 +1,163 @@
+package sardine
+
+import (
+	"context"
+	"math"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
+)
+
+func TestPutMetricDataBuffer(t *testing.T) {
+	ts := time.Unix(1512057958, 0)
+	newBatch := func(id string, n int, ds []types.Dimension) *Batch {
+		b := &Batch{PluginID: id}
+		if ds != nil {
+			b.Dimensions = [][]types.Dimension{ds}
+		}
+		for i := 0; i < n; i++ {
+			b.Metrics = append(b.Metrics, &Metric{Namespace: "test/cmd", Name: "get", Value: float64(i), Timestamp: ts})
+		}
+		b.Metrics = append(b.Metrics, &Metric{Namespace: "test/other", Name: "set", Value: 1, Timestamp: ts})
+		return b
+	}
+
+	t.Run("coalesce plugins", func(t *testing.T) {
+		buf := newPutMetricDataBuffer()
+		for _, b := range []*Batch{newBatch("a", 10, nil), newBatch("b", 10, nil)} {
+			mds := newMetricData(b)
+			for _, ns := range sortedKeys(mds) {
+				if ins := buf.add(ns, mds[ns]); len(ins) != 0 {
+					t.Errorf("unexpected inputs before flush %d", len(ins))
+				}
+			}
+		}
+		ins := buf.flush()
+		if len(ins) != 2 {
+			t.Fatalf("unexpected inputs len expected:2 got:%d", len(ins))
+		}
+		if ns, n := aws.ToString(ins[0].Namespace), len(ins[0].MetricData); ns != "test/cmd" || n != 20 {
+			t.Errorf("unexpected input %s %d", ns, n)
+		}
+		if ns, n := aws.ToString(ins[1].Namespace), len(ins[1].MetricData); ns != "test/other" || n != 2 {
+			t.Errorf("unexpected input %s %d", ns, n)
+		}
+		if ins := buf.flush(); len(ins) != 0 {
+			t.Errorf("buffer must be empty after flush. got %d inputs", len(ins))
+		}
+	})
+
+	t.Run("storage resolution", func(t *testing.T) {
+		b := newBatch("a", 1, nil)
+		b.StorageResolution = 1
+		for _, md := range newMetricData(b)["test/cmd"] {
+			if aws.ToInt32(md.StorageResolution) != 1 {
+				t.Errorf("unexpected storage resolution %d", aws.ToInt32(md.StorageResolution))
+			}
+		}
+		for _, md := range newMetricData(newBatch("a", 1, nil))["test/cmd"] {
+			if md.StorageResolution != nil {
+				t.Errorf("storage resolution must not be set by default")
+			}
+		}
+	})
+
+	t.Run("count limit", func(t *testing.T) {
+		buf := newPutMetricDataBuffer()
+		mds := newMetricData(newBatch("a", 1500, nil))
+		ins := buf.add("test/cmd", mds["test/cmd"])
+		if len(ins) != 1 || len(ins[0].MetricData) != maxMetricDatum {
+			t.Fatalf("unexpected inputs %#v", ins)
+		}
+		ins = buf.flush()
+		if len(ins) != 1 || len(ins[0].MetricData) != 500 {
+			t.Fatalf("unexpected inputs %#v", ins)
+		}
+	})
+
+	t.Run("size limit", func(t *testing.T) {
+		buf := newPutMetricDataBuffer()
+		ds := []types.Dimension{{Name: aws.String("Long"), Value: aws.String(strings.Repeat("x", 2000))}}
+		mds := newMetricData(newBatch("a", 999, ds))
+		ins := append(buf.add("test/cmd", mds["test/cmd"]), buf.flush()...)
+		var total int
+		for _, in := range ins {
+			if aws.ToString(in.Namespace) != "test/cmd" {
+				continue
+			}
+			var size int
+			for _, md := range in.MetricData {
+				size += metricDatumSize(md)
+			}
+			if size > maxPutMetricDataSize {
+				t.Errorf("input exceeds the size limit %d", size)
+			}
+			total += len(in.MetricData)
+		}
+		if total != 999*2 {
+			t.Errorf("unexpected total datums expected:%d got:%d", 999*2, total)
+		}
+		if len(ins) < 3 {
+			t.Errorf("inputs must be split by size. got %d inputs", len(ins))
+		}
+	})
+
+	t.Run("non-finite values", func(t *testing.T) {
+		b := newBatch("a", 2, nil)
+		b.Metrics = append(b.Metrics,
+			&Metric{Namespace: "test/cmd", Name: "nan", Value: math.NaN(), Timestamp: ts},
+			&Metric{Namespace: "test/cmd", Name: "inf", Value: math.Inf(-1), Timestamp: ts},
+		)
+		mds := newMetricData(b)
+		if len(mds["test/cmd"]) != 2 {
+			t.Errorf("non-finite values must be dropped. got %d datums", len(mds["test/cmd"]))
+		}
+		for _, md := range mds["test/cmd"] {
+			if name := aws.ToString(md.MetricName); name != "get" {
+				t.Errorf("unexpected metric %s", name)
+			}
+		}
+	})
+}
+
+func TestCloudWatchSinkCloseTimeout(t *testing.T) {
+	fctx, cancel := context.WithCancel(context.Background())
+	s := &cloudWatchSink{
+		q:       newQueue("cloudwatch", 1),
+		buf:     newPutMetricDataBuffer(),
+		agg:     newStatisticAggregator(),
+		cancel:  cancel,
+		stopped: make(chan struct{}),
+	}
+	ts := time.Now()
+	for _, ns := range []string{"test/a", "test/b", "test/c", "test/d"} {
+		s.buf.add(ns, []types.MetricDatum{{MetricName: aws.String("get"), Value: aws.Float64(1), Timestamp: &ts}})
+	}
+	// a slow sender blocks the queue, and flushPeriodically is blocked by the full queue.
+	go s.q.run(func(ctx context.Context, v interface{}) bool {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(5 * time.Second):
+			return true
+		}
+	}, 0, nil)
+	go s.flushPeriodically(fctx, 10*time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+
+	ctx, cancelClose := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancelClose()
+	start := time.Now()
+	s.Close(ctx)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Close must return in the timeout: %s", elapsed)
+	}
+	// the batch being sent and the queued batch are dropped.
+	if d, n := atomic.LoadInt64(&s.q.dropped), atomic.LoadInt64(&s.q.sending); d != 2 || n != 0 {
+		t.Errorf("unexpected counts of the queue dropped:%d sending:%d", d, n)
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"log"
 	"os"
@@ -28,8 +29,33 @@ func main() {
 	flag.BoolVar(&sardine.Debug, "debug", false, "enable debug logging")
 	flag.DurationVar(&sleep, "sleep", 0, "sleep duration at wake up")
 	flag.BoolVar(&atOnce, "at-once", false, "run at once and exit")
+	flag.DurationVar(&sardine.ShutdownTimeout, "shutdown-timeout", sardine.ShutdownTimeout, "timeout to flush queued metrics on shutdown")
+	flag.BoolVar(&sardine.DryRun, "dry-run", false, "print metrics to stdout instead of sending them")
+	flag.StringVar(&sardine.DryRunFormat, "dry-run-format", "text", "output format of dry-run (text or json)")
+	flag.DurationVar(&sardine.ConfigPollInterval, "config-poll-interval", 0, "interval to poll the config from http(s) or s3 URL and reload it when changed")
 	flag.VisitAll(envToFlag)
-	flag.Parse()
+
+	// sardine validate [flags] or sardine [flags] validate
+	args := os.Args[1:]
+	var validate bool
+	if len(args) > 0 && args[0] == "validate" {
+		validate = true
+		args = args[1:]
+	}
+	flag.CommandLine.Parse(args)
+	if flag.Arg(0) == "validate" {
+		validate = true
+	}
+
+	if validate {
+		os.Exit(runValidate(config))
+	}
+	switch sardine.DryRunFormat {
+	case "text", "json":
+	default:
+		log.Printf("invalid -dry-run-format %s. use text or json", sardine.DryRunFormat)
+		os.Exit(1)
+	}
 
 	log.Println("starting sardine agent")
 	if sleep > 0 {
@@ -54,6 +80,24 @@ func main() {
 	}
 }
 
+func runValidate(config string) int {
+	err := sardine.ValidateConfig(context.Background(), config)
+	if err == nil {
+		log.Printf("%s is valid", config)
+		return 0
+	}
+	var errs sardine.ConfigErrors
+	if errors.As(err, &errs) {
+		for _, err := range errs {
+			log.Println(err)
+		}
+	} else {
+		log.Println(err)
+	}
+	log.Printf("%s is invalid", config)
+	return 1
+}
+
 func envToFlag(f *flag.Flag) {
 	names := []string{
 		strings.ToUpper(strings.Replace(f.Name, "-", "_", -1)),
@@ -8,6 +8,7 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"path"
 	"strings"
 	"time"
 
@@ -21,10 +22,26 @@ import (
 
 type Config struct {
 	Plugin        map[string]map[string]*PluginConfig
+	Retry         RetryConfig
+	Spool         SpoolConfig
+	CloudWatch    CloudWatchConfig
+	Prometheus    PrometheusConfig
+	OTLP          OTLPConfig
 	CheckPlugins  map[string]*CheckPlugin
 	MetricPlugins map[string]MetricPlugin
 }
 
+// ConfigErrors represents all errors found in a config.
+type ConfigErrors []error
+
+func (errs ConfigErrors) Error() string {
+	msgs := make([]string, 0, len(errs))
+	for _, err := range errs {
+		msgs = append(msgs, err.Error())
+	}
+	return strings.Join(msgs, "\n")
+}
+
 type duration struct {
 	time.Duration
 }
@@ -36,13 +53,52 @@ func (d *duration) UnmarshalText(text []byte) error {
 }
 
 type PluginConfig struct {
-	Namespace   string
-	Command     string
-	Timeout     duration
-	Interval    duration
-	Dimensions  []*Dimension
-	Destination string
-	Service     string
+	Namespace          string
+	Command            string
+	Timeout            duration
+	Interval           duration
+	Dimensions         []*Dimension
+	Destination        string
+	Destinations       []string
+	DestinationOptions map[string]*DestinationOptions `toml:"destination_options"`
+	Service            string
+	StorageResolution  int32    `toml:"storage_resolution"`
+	AggregationWindow  duration `toml:"aggregation_window"`
+	Unit               string
+	Units              map[string]string
+	Rate               map[string]string
+	Include            []string
+	Exclude            []string
+	NamespacePrefix    string `toml:"namespace_prefix"`
+	NamespaceSegments  *int   `toml:"namespace_segments"`
+	Rewrite            []*RewriteRule
+	ExtractDimensions  []string `toml:"extract_dimensions"`
+	Transform          map[string]*TransformRule
+	Expressions        map[string]string
+	PluginMeta         *bool    `toml:"plugin_meta"`
+	HostID             string   `toml:"host_id"`
+	MaxCheckAttempts   int      `toml:"max_check_attempts"`
+	RetryInterval      duration `toml:"retry_interval"`
+	AllStates          bool     `toml:"all_states"`
+	Type               string
+	Address            string
+	Send               string
+	Expect             string
+	URL                string `toml:"url"`
+	Status             []int
+	CertExpiryWarning  duration `toml:"cert_expiry_warning"`
+	CertExpiryCritical duration `toml:"cert_expiry_critical"`
+	Insecure           bool
+	Host               string
+	Server             string
+	Process            string
+	Pidfile            string
+}
+
+// DestinationOptions overrides options of a plugin for each destination.
+type DestinationOptions struct {
+	Service    string
+	Dimensions []*Dimension
 }
 
 type Dimension string
@@ -64,55 +120,164 @@ func (d *Dimension) CloudWatchDimensions() ([]types.Dimension, error) {
 	return ds, nil
 }
 
-func (pc *PluginConfig) NewCloudWatchMetricPlugin(id string) (*CloudWatchMetricPlugin, error) {
-	if pc.Command == "" {
-		return nil, fmt.Errorf("command required")
+// destination returns the destination name of the plugin. The default destination is cloudwatch.
+func (pc *PluginConfig) destination() (string, error) {
+	return normalizeDestination(pc.Destination)
+}
+
+func normalizeDestination(name string) (string, error) {
+	d := strings.ToLower(name)
+	if d == "" {
+		d = "cloudwatch"
 	}
-	args, err := shellwords.Parse(pc.Command)
-	if err != nil {
-		return nil, fmt.Errorf("parse command failed: %w", err)
+	if _, ok := lookupSinkFactory(d); !ok {
+		return "", fmt.Errorf("destination %s is not registered", name)
 	}
-	dimensions := [][]types.Dimension{}
-	for _, d := range pc.Dimensions {
-		if ds, err := d.CloudWatchDimensions(); err != nil {
+	return d, nil
+}
+
+// destinations returns the destinations of the metric plugin with options.
+func (pc *PluginConfig) destinations() ([]*Destination, error) {
+	names := pc.Destinations
+	if len(names) == 0 {
+		names = []string{pc.Destination}
+	} else if pc.Destination != "" {
+		return nil, fmt.Errorf("destination and destinations are exclusive")
+	}
+	options := make(map[string]*DestinationOptions, len(pc.DestinationOptions))
+	for name, opt := range pc.DestinationOptions {
+		options[strings.ToLower(name)] = opt
+	}
+
+	var dests []*Destination
+	seen := make(map[string]bool, len(names))
+	for _, name := range names {
+		d, err := normalizeDestination(name)
+		if err != nil {
 			return nil, err
-		} else {
-			dimensions = append(dimensions, ds)
 		}
+		if seen[d] {
+			return nil, fmt.Errorf("destination %s is duplicated", d)
+		}
+		seen[d] = true
+
+		dest := &Destination{
+			Name:       d,
+			Dimensions: [][]types.Dimension{},
+			Service:    pc.Service,
+		}
+		dimensions := pc.Dimensions
+		if opt := options[d]; opt != nil {
+			if opt.Service != "" {
+				dest.Service = opt.Service
+			}
+			if opt.Dimensions != nil {
+				dimensions = opt.Dimensions
+			}
+		}
+		for _, d := range dimensions {
+			if ds, err := d.CloudWatchDimensions(); err != nil {
+				return nil, err
+			} else {
+				dest.Dimensions = append(dest.Dimensions, ds)
+			}
+		}
+		if d == "mackerel" && dest.Service == "" {
+			return nil, fmt.Errorf("service required")
+		}
+		dests = append(dests, dest)
 	}
-	mp := &CloudWatchMetricPlugin{
-		id:         fmt.Sprintf("plugin.metrics.%s", id),
-		command:    args,
-		timeout:    pc.Timeout.Duration,
-		interval:   pc.Interval.Duration,
-		Dimensions: dimensions,
+	for name := range options {
+		if !seen[name] {
+			return nil, fmt.Errorf("destination_options.%s is not in destinations", name)
+		}
 	}
-	if mp.timeout == 0 {
-		mp.timeout = DefaultCommandTimeout
+	return dests, nil
+}
+
+// storageResolution returns the storage resolution of CloudWatch metrics.
+// High resolution (1) is allowed only for plugins running more often than every minute.
+func (pc *PluginConfig) storageResolution(interval time.Duration) (int32, error) {
+	switch pc.StorageResolution {
+	case 0, 60:
+		return pc.StorageResolution, nil
+	case 1:
+		if interval >= time.Minute {
+			return 0, fmt.Errorf("storage_resolution 1 requires interval less than 1m. got %s", interval)
+		}
+		return 1, nil
+	default:
+		return 0, fmt.Errorf("storage_resolution must be 1 or 60. got %d", pc.StorageResolution)
 	}
-	if mp.interval == 0 {
-		mp.interval = DefaultInterval
+}
+
+// units returns the default unit and units for metric name patterns of the plugin.
+func (pc *PluginConfig) units() (types.StandardUnit, map[string]types.StandardUnit, error) {
+	unit, err := parseUnit(pc.Unit)
+	if err != nil {
+		return "", nil, err
 	}
-	return mp, nil
+	units := make(map[string]types.StandardUnit, len(pc.Units))
+	for pattern, u := range pc.Units {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return "", nil, fmt.Errorf("invalid pattern %s in units: %w", pattern, err)
+		}
+		if units[pattern], err = parseUnit(u); err != nil {
+			return "", nil, err
+		}
+	}
+	return unit, units, nil
 }
 
+func parseUnit(s string) (types.StandardUnit, error) {
+	if s == "" {
+		return "", nil
+	}
+	for _, u := range types.StandardUnit("").Values() {
+		if string(u) == s {
+			return u, nil
+		}
+	}
+	return "", fmt.Errorf("unit %s is not a valid CloudWatch unit", s)
+}
+
+// NewCloudWatchMetricPlugin returns a metric plugin which sends metrics to the destination cloudwatch.
+//
+// Deprecated: Use NewMetricPlugin with destination = "cloudwatch".
+func (pc *PluginConfig) NewCloudWatchMetricPlugin(id string) (*CloudWatchMetricPlugin, error) {
+	c := *pc
+	c.Destination, c.Destinations, c.DestinationOptions = "cloudwatch", nil, nil
+	return c.NewMetricPlugin(id)
+}
+
+// NewMackerelMetricPlugin returns a metric plugin which sends metrics to the destination mackerel.
+//
+// Deprecated: Use NewMetricPlugin with destination = "mackerel".
 func (pc *PluginConfig) NewMackerelMetricPlugin(id string) (*MackerelMetricPlugin, error) {
+	c := *pc
+	c.Destination, c.Destinations, c.DestinationOptions = "mackerel", nil, nil
+	return c.NewMetricPlugin(id)
+}
+
+func (pc *PluginConfig) NewMetricPlugin(id string) (*CommandMetricPlugin, error) {
 	if pc.Command == "" {
 		return nil, fmt.Errorf("command required")
 	}
-	if pc.Service == "" {
-		return nil, fmt.Errorf("service required")
+	dests, err := pc.destinations()
+	if err != nil {
+		return nil, err
 	}
 	args, err := shellwords.Parse(pc.Command)
 	if err != nil {
 		return nil, fmt.Errorf("parse command failed: %w", err)
 	}
-	mp := &MackerelMetricPlugin{
-		id:       fmt.Sprintf("plugin.servicemetrics.%s", id),
-		command:  args,
-		timeout:  pc.Timeout.Duration,
-		interval: pc.Interval.Duration,
-		Service:  pc.Service,
+	mp := &CommandMetricPlugin{
+		id:           fmt.Sprintf("plugin.metrics.%s", id),
+		command:      args,
+		timeout:      pc.Timeout.Duration,
+		interval:     pc.Interval.Duration,
+		Destinations: dests,
+		pluginMeta:   pc.PluginMeta == nil || *pc.PluginMeta,
 	}
 	if mp.timeout == 0 {
 		mp.timeout = DefaultCommandTimeout
@@ -120,6 +285,35 @@ func (pc *PluginConfig) NewMackerelMetricPlugin(id string) (*MackerelMetricPlugi
 	if mp.interval == 0 {
 		mp.interval = DefaultInterval
 	}
+	if mp.StorageResolution, err = pc.storageResolution(mp.interval); err != nil {
+		return nil, err
+	}
+	if mp.Unit, mp.Units, err = pc.units(); err != nil {
+		return nil, err
+	}
+	if w := pc.AggregationWindow.Duration; w != 0 && w < mp.interval {
+		return nil, fmt.Errorf("aggregation_window must not be shorter than interval %s", mp.interval)
+	}
+	mp.AggregationWindow = pc.AggregationWindow.Duration
+	if mp.naming, err = newMetricNaming(pc); err != nil {
+		return nil, err
+	}
+	for _, pattern := range pc.ExtractDimensions {
+		r, err := newDimensionRule(pattern, mp.naming.minSegments())
+		if err != nil {
+			return nil, err
+		}
+		mp.dimensions = append(mp.dimensions, r)
+	}
+	if mp.transformer, err = newMetricTransformer(mp.id, pc); err != nil {
+		return nil, err
+	}
+	if mp.filter, err = newMetricFilter(pc.Include, pc.Exclude); err != nil {
+		return nil, err
+	}
+	if mp.rates, err = newRateCalculator(mp.id, pc.Rate); err != nil {
+		return nil, err
+	}
 	return mp, nil
 }
 
@@ -127,19 +321,39 @@ func (pc *PluginConfig) NewCheckPlugin(id string) (*CheckPlugin, error) {
 	if pc.Namespace == "" {
 		return nil, fmt.Errorf("namespace required")
 	}
-	if pc.Command == "" {
-		return nil, fmt.Errorf("command required")
-	}
-	args, err := shellwords.Parse(pc.Command)
+	chk, err := newChecker(pc)
 	if err != nil {
-		return nil, fmt.Errorf("parse command failed: %w", err)
+		return nil, err
+	}
+	var args []string
+	if chk == nil {
+		if pc.Command == "" {
+			return nil, fmt.Errorf("command required")
+		}
+		if args, err = shellwords.Parse(pc.Command); err != nil {
+			return nil, fmt.Errorf("parse command failed: %w", err)
+		}
 	}
 	cp := &CheckPlugin{
 		ID:        fmt.Sprintf("plugin.check.%s", id),
+		Name:      id,
 		Namespace: pc.Namespace,
 		Command:   args,
 		Timeout:   pc.Timeout.Duration,
 		Interval:  pc.Interval.Duration,
+		checker:   chk,
+	}
+	if len(pc.Destinations) > 0 || len(pc.DestinationOptions) > 0 {
+		return nil, fmt.Errorf("destinations are not supported for check plugins. use destination")
+	}
+	if cp.Destination, err = pc.destination(); err != nil {
+		return nil, err
+	}
+	if cp.Destination == "mackerel" {
+		if pc.HostID == "" {
+			return nil, fmt.Errorf("host_id required for destination mackerel")
+		}
+		cp.HostID = pc.HostID
 	}
 	for _, d := range pc.Dimensions {
 		if ds, err := d.CloudWatchDimensions(); err != nil {
@@ -154,60 +368,113 @@ func (pc *PluginConfig) NewCheckPlugin(id string) (*CheckPlugin, error) {
 	if cp.Interval == 0 {
 		cp.Interval = DefaultInterval
 	}
+	if cp.StorageResolution, err = pc.storageResolution(cp.Interval); err != nil {
+		return nil, err
+	}
+	if pc.MaxCheckAttempts < 0 {
+		return nil, fmt.Errorf("max_check_attempts must not be negative")
+	}
+	cp.MaxCheckAttempts = pc.MaxCheckAttempts
+	if cp.MaxCheckAttempts == 0 {
+		cp.MaxCheckAttempts = 1
+	}
+	cp.AllStates = pc.AllStates
+	cp.RetryInterval = pc.RetryInterval.Duration
+	if cp.RetryInterval == 0 {
+		cp.RetryInterval = cp.Interval
+	}
 	return cp, nil
 }
 
 func LoadConfig(ctx context.Context, path string) (*Config, error) {
-	c := &Config{
-		CheckPlugins:  make(map[string]*CheckPlugin),
-		MetricPlugins: make(map[string]MetricPlugin),
-	}
 	configBytes, err := loadURL(ctx, path)
 	if err != nil {
 		return nil, fmt.Errorf("failed to load config: %w", err)
 	}
+	c, err := parseConfig(configBytes)
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// parseConfig parses the config bytes.
+// When some plugins are invalid, it returns ConfigErrors with the config which contains valid plugins only.
+func parseConfig(configBytes []byte) (*Config, error) {
+	c := &Config{
+		CheckPlugins:  make(map[string]*CheckPlugin),
+		MetricPlugins: make(map[string]MetricPlugin),
+	}
 	if err := config.LoadWithEnvTOMLBytes(c, configBytes); err != nil {
 		return nil, err
 	}
 
-	for key, value := range c.Plugin {
+	var errs ConfigErrors
+	if err := c.Retry.setDefaults(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := c.Spool.setDefaults(); err != nil {
+		errs = append(errs, err)
+	}
+	for _, key := range sortedKeys(c.Plugin) {
+		value := c.Plugin[key]
 		switch key {
 		case "metrics":
-			for id, pc := range value {
-				switch strings.ToLower(pc.Destination) {
-				case "mackerel":
-					d, err := pc.NewMackerelMetricPlugin(id)
-					if err != nil {
-						return nil, err
-					}
-					c.MetricPlugins[id] = d
-				case "cloudwatch", "":
-					d, err := pc.NewCloudWatchMetricPlugin(id)
-					if err != nil {
-						return nil, err
-					}
-					c.MetricPlugins[id] = d
-				default:
-					return nil, fmt.Errorf("destination %s is not allowed. use cloudwatch or mackerel", pc.Destination)
+			for _, id := range sortedKeys(value) {
+				mp, err := value[id].NewMetricPlugin(id)
+				if err != nil {
+					errs = append(errs, fmt.Errorf("[plugin.metrics.%s] %w", id, err))
+					continue
 				}
+				c.MetricPlugins[id] = mp
 			}
 		case "check":
-			for id, pc := range value {
-				cp, err := pc.NewCheckPlugin(id)
+			for _, id := range sortedKeys(value) {
+				cp, err := value[id].NewCheckPlugin(id)
 				if err != nil {
-					return nil, err
+					errs = append(errs, fmt.Errorf("[plugin.check.%s] %w", id, err))
+					continue
 				}
 				c.CheckPlugins[id] = cp
 			}
 		default:
-			return nil, fmt.Errorf("unknown config section [plugin.%s]", key)
+			errs = append(errs, fmt.Errorf("unknown config section [plugin.%s]", key))
 		}
 	}
-	c.Plugin = nil
-
+	c.CloudWatch.setDefaults()
+	c.Prometheus.setDefaults()
+	if err := c.OTLP.setDefaults(); err != nil {
+		errs = append(errs, err)
+	}
+	if c.Prometheus.Listen == "" && c.usePrometheus() {
+		errs = append(errs, fmt.Errorf("[prometheus] listen required for destination prometheus"))
+	}
+	if len(errs) > 0 {
+		return c, errs
+	}
 	return c, nil
 }
 
+func (c *Config) usePrometheus() bool {
+	for _, mp := range c.MetricPlugins {
+		mp, ok := mp.(*CommandMetricPlugin)
+		if !ok {
+			continue
+		}
+		for _, d := range mp.Destinations {
+			if d.Name == "prometheus" {
+				return true
+			}
+		}
+	}
+	for _, cp := range c.CheckPlugins {
+		if cp.Destination == "prometheus" {
+			return true
+		}
+	}
+	return false
+}
+
 func loadURL(ctx context.Context, p string) ([]byte, error) {
 	u, err := url.Parse(p)
 	if err != nil {
@@ -233,6 +500,9 @@ func fetchHTTP(ctx context.Context, u *url.URL) ([]byte, error) {
 		return nil, err
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("unexpected response status %s from %s", resp.Status, u)
+	}
 	return io.ReadAll(resp.Body)
 }
 
@@ -242,7 +512,7 @@ func fetchS3(ctx context.Context, u *url.URL) ([]byte, error) {
 	region := os.Getenv("AWS_REGION")
 	awscfg, err := awsConfig.LoadDefaultConfig(ctx, awsConfig.WithRegion(region))
 	if err != nil {
-		panic(fmt.Errorf("failed to load aws config: %w", err))
+		return nil, fmt.Errorf("failed to load aws config: %w", err)
 	}
 	svc := s3.NewFromConfig(awscfg)
 	out, err := svc.GetObject(ctx, &s3.GetObjectInput{
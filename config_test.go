@@ -2,6 +2,7 @@ package sardine_test
 
 import (
 	"context"
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"reflect"
@@ -16,12 +17,15 @@ func TestLoadConfig(t *testing.T) {
 	if err != nil {
 		t.Error(err)
 	}
-	cmp := c.MetricPlugins["memcached"].(*sardine.CloudWatchMetricPlugin)
+	cmp := c.MetricPlugins["memcached"].(*sardine.CommandMetricPlugin)
 	if !reflect.DeepEqual(cmp.Command(), []string{"mackerel-plugin-memcached", "--host", "127.0.0.1", "--port", "11211"}) {
 		t.Errorf("unexpected command %#v", cmp.Command())
 	}
-	if len(cmp.Dimensions) != 2 {
-		t.Errorf("unexpected dimensions len expected:2 got:%d", len(cmp.Dimensions))
+	if len(cmp.Destinations) != 1 {
+		t.Fatalf("unexpected destinations len expected:1 got:%d", len(cmp.Destinations))
+	}
+	if len(cmp.Destinations[0].Dimensions) != 2 {
+		t.Errorf("unexpected dimensions len expected:2 got:%d", len(cmp.Destinations[0].Dimensions))
 	}
 	if cmp.Interval() != 10*time.Second {
 		t.Errorf("unexpected interval expected:10s got:%s", cmp.Interval())
@@ -29,6 +33,12 @@ func TestLoadConfig(t *testing.T) {
 	if cmp.Timeout() != 15*time.Second {
 		t.Errorf("unexpected timeout expected:15s got:%s", cmp.Timeout())
 	}
+	if cmp.StorageResolution != 1 {
+		t.Errorf("unexpected storage resolution expected:1 got:%d", cmp.StorageResolution)
+	}
+	if cmp.Destinations[0].Name != "cloudwatch" {
+		t.Errorf("unexpected destination expected:cloudwatch got:%s", cmp.Destinations[0].Name)
+	}
 
 	cp := c.CheckPlugins["memcached"]
 	if !reflect.DeepEqual(cp.Command, []string{"sh", "-c", "echo version | nc 127.0.0.1 11211"}) {
@@ -47,12 +57,51 @@ func TestLoadConfig(t *testing.T) {
 		t.Errorf("unexpected timeout expected:1m got:%s", cp.Timeout)
 	}
 
-	mmp := c.MetricPlugins["redis"].(*sardine.MackerelMetricPlugin)
+	mmp := c.MetricPlugins["redis"].(*sardine.CommandMetricPlugin)
 	if !reflect.DeepEqual(mmp.Command(), []string{"mackerel-plugin-redis"}) {
 		t.Errorf("unexpected command %#v", mmp.Command())
 	}
-	if mmp.Service != "production" {
-		t.Errorf("unexpected service %s", mmp.Service)
+	if mmp.Destinations[0].Name != "mackerel" {
+		t.Errorf("unexpected destination expected:mackerel got:%s", mmp.Destinations[0].Name)
+	}
+	if mmp.Destinations[0].Service != "production" {
+		t.Errorf("unexpected service %s", mmp.Destinations[0].Service)
+	}
+
+	fmp := c.MetricPlugins["fanout"].(*sardine.CommandMetricPlugin)
+	if len(fmp.Destinations) != 2 {
+		t.Fatalf("unexpected destinations len expected:2 got:%d", len(fmp.Destinations))
+	}
+	if d := fmp.Destinations[0]; d.Name != "cloudwatch" || len(d.Dimensions) != 1 {
+		t.Errorf("unexpected destination %#v", d)
+	}
+	if d := fmp.Destinations[1]; d.Name != "mackerel" || d.Service != "staging" || len(d.Dimensions) != 0 {
+		t.Errorf("unexpected destination %#v", d)
+	}
+}
+
+func TestDeprecatedMetricPlugins(t *testing.T) {
+	c, err := sardine.LoadConfig(context.Background(), "test/config.toml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := c.MetricPlugins["memcached"].(*sardine.CloudWatchMetricPlugin); !ok {
+		t.Error("CloudWatchMetricPlugin must be available as an alias")
+	}
+	pc := &sardine.PluginConfig{Command: "mackerel-plugin-redis", Service: "production", Destinations: []string{"cloudwatch"}}
+	mmp, err := pc.NewMackerelMetricPlugin("redis")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mmp.Destinations) != 1 || mmp.Destinations[0].Name != "mackerel" || mmp.Destinations[0].Service != "production" {
+		t.Errorf("unexpected destinations %#v", mmp.Destinations)
+	}
+	cmp, err := pc.NewCloudWatchMetricPlugin("redis")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cmp.Destinations) != 1 || cmp.Destinations[0].Name != "cloudwatch" {
+		t.Errorf("unexpected destinations %#v", cmp.Destinations)
 	}
 }
 
@@ -88,3 +137,31 @@ func TestLoadConfigFromHTTP(t *testing.T) {
 	}
 	t.Logf("%#v", c)
 }
+
+func TestValidateConfig(t *testing.T) {
+	err := sardine.ValidateConfig(context.Background(), "test/invalid.toml")
+	var errs sardine.ConfigErrors
+	if !errors.As(err, &errs) {
+		t.Fatalf("unexpected error %#v", err)
+	}
+	expected := []string{
+		"unknown key plugin.metrics.unknown_key.intreval",
+		"key plugin.metrics.check_key.url is available only in [plugin.check.*]",
+		"key plugin.check.metrics_key.units is available only in [plugin.metrics.*]",
+		"[plugin.check.no_host_id] host_id required for destination mackerel",
+		"[plugin.check.no_namespace] namespace required",
+		"[plugin.metrics.bad_aggregation] aggregation_window must not be shorter than interval 1m0s",
+		"[plugin.metrics.bad_destination] destination nowhere is not registered",
+		"[plugin.metrics.bad_resolution] storage_resolution 1 requires interval less than 1m. got 1m0s",
+		"[plugin.metrics.bad_unit] unit Byte is not a valid CloudWatch unit",
+		`[plugin.metrics.not_found] command sardine-command-not-found is not found: exec: "sardine-command-not-found": executable file not found in $PATH`,
+	}
+	if len(errs) != len(expected) {
+		t.Fatalf("unexpected errors len expected:%d got:%d %s", len(expected), len(errs), errs)
+	}
+	for i, err := range errs {
+		if err.Error() != expected[i] {
+			t.Errorf("unexpected error[%d] expected:%s got:%s", i, expected[i], err)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package sardine
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+)
+
+type dryRunMetric struct {
+	Destination string            `json:"destination"`
+	Namespace   string            `json:"namespace,omitempty"`
+	Service     string            `json:"service,omitempty"`
+	Name        string            `json:"name"`
+	Dimensions  map[string]string `json:"dimensions,omitempty"`
+	Value       float64           `json:"value"`
+	Unit        string            `json:"unit,omitempty"`
+	Statistics  *dryRunStatistics `json:"statistics,omitempty"`
+	HostID      string            `json:"host_id,omitempty"`
+	Status      string            `json:"status,omitempty"`
+	Message     string            `json:"message,omitempty"`
+	Timestamp   time.Time         `json:"timestamp"`
+}
+
+type dryRunStatistics struct {
+	SampleCount float64 `json:"sample_count"`
+	Sum         float64 `json:"sum"`
+	Minimum     float64 `json:"minimum"`
+	Maximum     float64 `json:"maximum"`
+}
+
+func (m *dryRunMetric) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s", m.Destination)
+	if m.Namespace != "" {
+		fmt.Fprintf(&b, " namespace=%s", m.Namespace)
+	}
+	if m.Service != "" {
+		fmt.Fprintf(&b, " service=%s", m.Service)
+	}
+	if m.HostID != "" {
+		fmt.Fprintf(&b, " host_id=%s", m.HostID)
+	}
+	fmt.Fprintf(&b, " name=%s", m.Name)
+	if len(m.Dimensions) > 0 {
+		ds := make([]string, 0, len(m.Dimensions))
+		for _, name := range sortedKeys(m.Dimensions) {
+			ds = append(ds, name+"="+m.Dimensions[name])
+		}
+		fmt.Fprintf(&b, " dimensions=%s", strings.Join(ds, ","))
+	}
+	if st := m.Statistics; st != nil {
+		fmt.Fprintf(&b, " sample_count=%g sum=%g minimum=%g maximum=%g", st.SampleCount, st.Sum, st.Minimum, st.Maximum)
+	} else if m.Status != "" {
+		fmt.Fprintf(&b, " status=%s message=%q", m.Status, m.Message)
+	} else {
+		fmt.Fprintf(&b, " value=%g", m.Value)
+	}
+	if m.Unit != "" {
+		fmt.Fprintf(&b, " unit=%s", m.Unit)
+	}
+	fmt.Fprintf(&b, " timestamp=%s", m.Timestamp.Format(time.RFC3339))
+	return b.String()
+}
+
+func writeDryRunMetric(w io.Writer, m *dryRunMetric) {
+	switch DryRunFormat {
+	case "json":
+		b, _ := json.Marshal(m)
+		fmt.Fprintln(w, string(b))
+	default:
+		fmt.Fprintln(w, m.String())
+	}
+}
+
+func printCloudWatch(w io.Writer, in *cloudwatch.PutMetricDataInput) {
+	for _, md := range in.MetricData {
+		m := &dryRunMetric{
+			Destination: "cloudwatch",
+			Namespace:   aws.ToString(in.Namespace),
+			Name:        aws.ToString(md.MetricName),
+			Value:       aws.ToFloat64(md.Value),
+			Unit:        string(md.Unit),
+			Timestamp:   aws.ToTime(md.Timestamp),
+		}
+		if st := md.StatisticValues; st != nil {
+			m.Statistics = &dryRunStatistics{
+				SampleCount: aws.ToFloat64(st.SampleCount),
+				Sum:         aws.ToFloat64(st.Sum),
+				Minimum:     aws.ToFloat64(st.Minimum),
+				Maximum:     aws.ToFloat64(st.Maximum),
+			}
+		}
+		if len(md.Dimensions) > 0 {
+			m.Dimensions = make(map[string]string, len(md.Dimensions))
+			for _, d := range md.Dimensions {
+				m.Dimensions[aws.ToString(d.Name)] = aws.ToString(d.Value)
+			}
+		}
+		writeDryRunMetric(w, m)
+	}
+}
+
+func printMackerel(w io.Writer, in ServiceMetric) {
+	for _, mv := range in.MetricValues {
+		v, _ := mv.Value.(float64)
+		writeDryRunMetric(w, &dryRunMetric{
+			Destination: "mackerel",
+			Service:     in.Service,
+			Name:        mv.Name,
+			Value:       v,
+			Timestamp:   time.Unix(mv.Time, 0),
+		})
+	}
+}
+
+func printMackerelCheckReport(w io.Writer, c *CheckReport) {
+	writeDryRunMetric(w, &dryRunMetric{
+		Destination: "mackerel",
+		HostID:      c.HostID,
+		Name:        c.Name,
+		Status:      string(mackerelCheckStatuses[c.Result]),
+		Message:     c.Message,
+		Timestamp:   c.Timestamp,
+	})
+}
+
+func printOTLP(w io.Writer, in *metricspb.ResourceMetrics) {
+	for _, sm := range in.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			for _, dp := range m.GetGauge().GetDataPoints() {
+				dm := &dryRunMetric{
+					Destination: "otlp",
+					Name:        m.Name,
+					Value:       dp.GetAsDouble(),
+					Timestamp:   time.Unix(0, int64(dp.TimeUnixNano)),
+				}
+				if len(dp.Attributes) > 0 {
+					dm.Dimensions = make(map[string]string, len(dp.Attributes))
+					for _, attr := range dp.Attributes {
+						dm.Dimensions[attr.Key] = attr.Value.GetStringValue()
+					}
+				}
+				writeDryRunMetric(w, dm)
+			}
+		}
+	}
+}
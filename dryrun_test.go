@@ -0,0 +1,56 @@
+package sardine
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
+	mackerel "github.com/mackerelio/mackerel-client-go"
+)
+
+func TestDryRun(t *testing.T) {
+	ts := time.Unix(1512057958, 0).UTC()
+	in := &cloudwatch.PutMetricDataInput{
+		Namespace: aws.String("memcached/cmd"),
+		MetricData: []types.MetricDatum{
+			{
+				MetricName: aws.String("cmd_get"),
+				Value:      aws.Float64(10),
+				Timestamp:  &ts,
+				Dimensions: []types.Dimension{
+					{Name: aws.String("Host"), Value: aws.String("127.0.0.1")},
+				},
+			},
+		},
+	}
+	sm := ServiceMetric{
+		Service: "production",
+		MetricValues: []*mackerel.MetricValue{
+			{Name: "memcached.cmd.cmd_get", Value: float64(10), Time: ts.Unix()},
+		},
+	}
+
+	cr := &CheckReport{
+		Name:      "memcached",
+		Namespace: "memcached/check",
+		Result:    CheckFailed,
+		Message:   "connection refused",
+		Timestamp: ts,
+		HostID:    "3Ae2Zp9Kx1",
+	}
+
+	var buf bytes.Buffer
+	printCloudWatch(&buf, in)
+	printMackerel(&buf, sm)
+	printMackerelCheckReport(&buf, cr)
+
+	expected := "cloudwatch namespace=memcached/cmd name=cmd_get dimensions=Host=127.0.0.1 value=10 timestamp=2017-11-30T16:05:58Z\n" +
+		"mackerel service=production name=memcached.cmd.cmd_get value=10 timestamp=" + ts.Local().Format(time.RFC3339) + "\n" +
+		"mackerel host_id=3Ae2Zp9Kx1 name=memcached status=CRITICAL message=\"connection refused\" timestamp=2017-11-30T16:05:58Z\n"
+	if got := buf.String(); got != expected {
+		t.Errorf("unexpected output expected:%s got:%s", expected, got)
+	}
+}
@@ -0,0 +1,204 @@
+package sardine
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// metricExpr is an arithmetic expression of metrics.
+// It supports numbers, metric names, + - * /, unary minus and parentheses.
+type metricExpr interface {
+	// eval evaluates the expression. lookup returns the value of a metric name.
+	eval(lookup func(string) (float64, bool)) (float64, error)
+}
+
+type numberExpr float64
+
+func (e numberExpr) eval(func(string) (float64, bool)) (float64, error) {
+	return float64(e), nil
+}
+
+type metricRefExpr string
+
+func (e metricRefExpr) eval(lookup func(string) (float64, bool)) (float64, error) {
+	if v, ok := lookup(string(e)); ok {
+		return v, nil
+	}
+	return 0, fmt.Errorf("metric %s not found", string(e))
+}
+
+type negExpr struct {
+	x metricExpr
+}
+
+func (e *negExpr) eval(lookup func(string) (float64, bool)) (float64, error) {
+	v, err := e.x.eval(lookup)
+	return -v, err
+}
+
+type binaryExpr struct {
+	op   byte
+	x, y metricExpr
+}
+
+func (e *binaryExpr) eval(lookup func(string) (float64, bool)) (float64, error) {
+	x, err := e.x.eval(lookup)
+	if err != nil {
+		return 0, err
+	}
+	y, err := e.y.eval(lookup)
+	if err != nil {
+		return 0, err
+	}
+	switch e.op {
+	case '+':
+		return x + y, nil
+	case '-':
+		return x - y, nil
+	case '*':
+		return x * y, nil
+	default:
+		if y == 0 {
+			return 0, fmt.Errorf("division by zero")
+		}
+		return x / y, nil
+	}
+}
+
+// parseMetricExpr parses an expression. e.g. get_hits / (get_hits + get_misses)
+// Metric names consist of letters, digits, '_' and '.', and must not start with a digit or '.'.
+func parseMetricExpr(s string) (metricExpr, error) {
+	p := &exprParser{s: s}
+	e, err := p.parseSum()
+	if err != nil {
+		return nil, err
+	}
+	if p.skipSpaces(); p.pos < len(p.s) {
+		return nil, fmt.Errorf("unexpected %q at %d", p.s[p.pos], p.pos)
+	}
+	return e, nil
+}
+
+type exprParser struct {
+	s   string
+	pos int
+}
+
+func (p *exprParser) skipSpaces() {
+	for p.pos < len(p.s) && (p.s[p.pos] == ' ' || p.s[p.pos] == '\t') {
+		p.pos++
+	}
+}
+
+// peek returns the next non-space character, or 0 at the end.
+func (p *exprParser) peek() byte {
+	p.skipSpaces()
+	if p.pos < len(p.s) {
+		return p.s[p.pos]
+	}
+	return 0
+}
+
+func (p *exprParser) parseSum() (metricExpr, error) {
+	x, err := p.parseProduct()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op := p.peek()
+		if op != '+' && op != '-' {
+			return x, nil
+		}
+		p.pos++
+		y, err := p.parseProduct()
+		if err != nil {
+			return nil, err
+		}
+		x = &binaryExpr{op: op, x: x, y: y}
+	}
+}
+
+func (p *exprParser) parseProduct() (metricExpr, error) {
+	x, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op := p.peek()
+		if op != '*' && op != '/' {
+			return x, nil
+		}
+		p.pos++
+		y, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		x = &binaryExpr{op: op, x: x, y: y}
+	}
+}
+
+func (p *exprParser) parseUnary() (metricExpr, error) {
+	if p.peek() == '-' {
+		p.pos++
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &negExpr{x: x}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *exprParser) parsePrimary() (metricExpr, error) {
+	c := p.peek()
+	switch {
+	case c == 0:
+		return nil, fmt.Errorf("unexpected end of expression")
+	case c == '(':
+		p.pos++
+		x, err := p.parseSum()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ')' {
+			return nil, fmt.Errorf("missing ) at %d", p.pos)
+		}
+		p.pos++
+		return x, nil
+	case isDigit(c) || c == '.':
+		start := p.pos
+		for p.pos < len(p.s) && (isDigit(p.s[p.pos]) || p.s[p.pos] == '.') {
+			p.pos++
+		}
+		if p.pos < len(p.s) && (p.s[p.pos] == 'e' || p.s[p.pos] == 'E') {
+			p.pos++
+			if p.pos < len(p.s) && (p.s[p.pos] == '+' || p.s[p.pos] == '-') {
+				p.pos++
+			}
+			for p.pos < len(p.s) && isDigit(p.s[p.pos]) {
+				p.pos++
+			}
+		}
+		v, err := strconv.ParseFloat(p.s[start:p.pos], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %s at %d", p.s[start:p.pos], start)
+		}
+		return numberExpr(v), nil
+	case isNameChar(c):
+		start := p.pos
+		for p.pos < len(p.s) && (isNameChar(p.s[p.pos]) || isDigit(p.s[p.pos]) || p.s[p.pos] == '.') {
+			p.pos++
+		}
+		return metricRefExpr(p.s[start:p.pos]), nil
+	default:
+		return nil, fmt.Errorf("unexpected %q at %d", c, p.pos)
+	}
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+func isNameChar(c byte) bool {
+	return c == '_' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
@@ -0,0 +1,97 @@
+package sardine
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
+)
+
+// dimensionRule extracts dimensions from segments of a metric name.
+// e.g. "disk.iostat.{Device}.*" extracts Device=sda from disk.iostat.sda.reads, and the name becomes disk.iostat.reads.
+type dimensionRule struct {
+	segments []string
+}
+
+// newDimensionRule returns a rule of the pattern.
+// The name without the captured segments must have minSegments segments at least, to keep its namespace and name.
+func newDimensionRule(pattern string, minSegments int) (*dimensionRule, error) {
+	r := &dimensionRule{segments: strings.Split(pattern, ".")}
+	var captures int
+	for _, seg := range r.segments {
+		if name, ok := captureName(seg); ok {
+			if name == "" {
+				return nil, fmt.Errorf("invalid pattern %s in extract_dimensions: empty dimension name", pattern)
+			}
+			captures++
+			continue
+		}
+		if _, err := path.Match(seg, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern %s in extract_dimensions: %w", pattern, err)
+		}
+	}
+	if captures == 0 {
+		return nil, fmt.Errorf("invalid pattern %s in extract_dimensions: no {Name} segment", pattern)
+	}
+	// a trailing "*" matches one segment at least.
+	if rest := len(r.segments) - captures; rest < minSegments {
+		return nil, fmt.Errorf("invalid pattern %s in extract_dimensions: %d segments are left without dimensions, but %d segments are required for the namespace and the name", pattern, rest, minSegments)
+	}
+	return r, nil
+}
+
+func captureName(seg string) (string, bool) {
+	if strings.HasPrefix(seg, "{") && strings.HasSuffix(seg, "}") {
+		return seg[1 : len(seg)-1], true
+	}
+	return "", false
+}
+
+// extract returns the metric name without the captured segments, and the dimensions.
+// Each segment of the pattern matches one segment of the name. A trailing "*" matches the rest of the name.
+func (r *dimensionRule) extract(name string) (string, []types.Dimension, bool) {
+	segs := strings.Split(name, ".")
+	last := len(r.segments) - 1
+	if len(segs) < len(r.segments) || len(segs) > len(r.segments) && r.segments[last] != "*" {
+		return "", nil, false
+	}
+	rest := make([]string, 0, len(segs))
+	var ds []types.Dimension
+	for i, pseg := range r.segments {
+		if i == last && pseg == "*" {
+			rest = append(rest, segs[i:]...)
+			break
+		}
+		if dn, ok := captureName(pseg); ok {
+			ds = append(ds, types.Dimension{Name: aws.String(dn), Value: aws.String(segs[i])})
+			continue
+		}
+		if ok, _ := path.Match(pseg, segs[i]); !ok {
+			return "", nil, false
+		}
+		rest = append(rest, segs[i])
+	}
+	return strings.Join(rest, "."), ds, true
+}
+
+// extractDimensions applies the first rule which matches the name.
+func extractDimensions(rules []*dimensionRule, name string) (string, []types.Dimension) {
+	for _, r := range rules {
+		if n, ds, ok := r.extract(name); ok {
+			return n, ds
+		}
+	}
+	return name, nil
+}
+
+// mergeDimensions returns a dimension set which has ds and extra.
+func mergeDimensions(ds, extra []types.Dimension) []types.Dimension {
+	if len(extra) == 0 {
+		return ds
+	}
+	merged := make([]types.Dimension, 0, len(ds)+len(extra))
+	merged = append(merged, ds...)
+	return append(merged, extra...)
+}
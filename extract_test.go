@@ -0,0 +1,70 @@
+package sardine
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+func TestExtractDimensions(t *testing.T) {
+	conf, err := parseConfig([]byte(`
+[plugin.metrics.disk]
+command            = "true"
+extract_dimensions = ["disk.iostat.{Device}.*", "custom.{Host}.sd*.{Device}.*"]
+
+[plugin.metrics.redis]
+command            = "true"
+namespace_segments = 1
+extract_dimensions = ["redis.keys.{DB}"]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		plugin     string
+		name       string
+		namespace  string
+		metric     string
+		dimensions string
+	}{
+		{"disk", "disk.iostat.sda.reads", "disk/iostat", "reads", "Device=sda"},
+		{"disk", "disk.iostat.sda.time.read", "disk/iostat", "time.read", "Device=sda"},
+		{"disk", "disk.iostat.sda", "disk/iostat", "sda", ""},
+		{"disk", "custom.web1.sdb.sda.reads", "custom/sdb", "reads", "Host=web1,Device=sda"},
+		{"disk", "custom.web1.hda.sda.reads", "custom/web1", "hda.sda.reads", ""},
+		{"redis", "redis.keys.db0", "redis", "keys", "DB=db0"},
+	}
+	for _, tt := range tests {
+		mp := conf.MetricPlugins[tt.plugin].(*CommandMetricPlugin)
+		m, err := mp.ParseMetricLine(tt.name + "\t1\t1512057958")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ds string
+		for i, d := range m.Dimensions {
+			if i > 0 {
+				ds += ","
+			}
+			ds += aws.ToString(d.Name) + "=" + aws.ToString(d.Value)
+		}
+		if m.Namespace != tt.namespace || m.Name != tt.metric || ds != tt.dimensions {
+			t.Errorf("unexpected metric of %s expected:%s %s %s got:%s %s %s", tt.name, tt.namespace, tt.metric, tt.dimensions, m.Namespace, m.Name, ds)
+		}
+		if m.RawName != tt.name {
+			t.Errorf("unexpected raw name %s expected:%s", m.RawName, tt.name)
+		}
+	}
+}
+
+func TestExtractDimensionsInvalid(t *testing.T) {
+	for _, pattern := range []string{"disk.iostat.*", "disk.{}.*", "disk.[.{Device}", "redis.keys.{DB}", "{Host}"} {
+		if _, err := newDimensionRule(pattern, DefaultNamespaceSegments+1); err == nil {
+			t.Errorf("pattern %s must be invalid", pattern)
+		}
+	}
+	for _, pattern := range []string{"redis.keys.{DB}", "{Host}.*"} {
+		if _, err := newDimensionRule(pattern, 1); err != nil {
+			t.Errorf("pattern %s must be valid for 1 segment: %s", pattern, err)
+		}
+	}
+}
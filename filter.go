@@ -0,0 +1,97 @@
+package sardine
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// metricMatcher matches metric names with a glob pattern, or a regexp enclosed in slashes. e.g. /^mysql\.innodb_/
+type metricMatcher struct {
+	glob string
+	re   *regexp.Regexp
+}
+
+func newMetricMatcher(s string) (*metricMatcher, error) {
+	if len(s) >= 2 && strings.HasPrefix(s, "/") && strings.HasSuffix(s, "/") {
+		re, err := regexp.Compile(s[1 : len(s)-1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid regexp %s: %w", s, err)
+		}
+		return &metricMatcher{re: re}, nil
+	}
+	if _, err := path.Match(s, ""); err != nil {
+		return nil, fmt.Errorf("invalid pattern %s: %w", s, err)
+	}
+	return &metricMatcher{glob: s}, nil
+}
+
+// match reports whether the metric name in the command output (e.g. memcached.cmd.cmd_get) or the name without the namespace (e.g. cmd_get) matches.
+func (mm *metricMatcher) match(m *Metric) bool {
+	full := m.fullName()
+	if mm.re != nil {
+		return mm.re.MatchString(full) || mm.re.MatchString(m.Name)
+	}
+	if ok, _ := path.Match(mm.glob, full); ok {
+		return true
+	}
+	ok, _ := path.Match(mm.glob, m.Name)
+	return ok
+}
+
+// metricFilter selects metrics by include and exclude lists.
+// When include is not empty, metrics must match any of include. Metrics which match any of exclude are dropped.
+type metricFilter struct {
+	include []*metricMatcher
+	exclude []*metricMatcher
+}
+
+func newMetricFilter(include, exclude []string) (*metricFilter, error) {
+	if len(include) == 0 && len(exclude) == 0 {
+		return nil, nil
+	}
+	f := &metricFilter{}
+	for _, s := range include {
+		mm, err := newMetricMatcher(s)
+		if err != nil {
+			return nil, fmt.Errorf("include: %w", err)
+		}
+		f.include = append(f.include, mm)
+	}
+	for _, s := range exclude {
+		mm, err := newMetricMatcher(s)
+		if err != nil {
+			return nil, fmt.Errorf("exclude: %w", err)
+		}
+		f.exclude = append(f.exclude, mm)
+	}
+	return f, nil
+}
+
+// apply returns metrics selected by the filter.
+func (f *metricFilter) apply(metrics []*Metric) []*Metric {
+	res := make([]*Metric, 0, len(metrics))
+	for _, m := range metrics {
+		if f.accept(m) {
+			res = append(res, m)
+		}
+	}
+	return res
+}
+
+func (f *metricFilter) accept(m *Metric) bool {
+	if len(f.include) > 0 && !matchAny(f.include, m) {
+		return false
+	}
+	return !matchAny(f.exclude, m)
+}
+
+func matchAny(mms []*metricMatcher, m *Metric) bool {
+	for _, mm := range mms {
+		if mm.match(m) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,48 @@
+package sardine
+
+import (
+	"testing"
+)
+
+func TestMetricFilter(t *testing.T) {
+	metrics := []*Metric{
+		{Namespace: "mysql/innodb", Name: "buffer_pool_reads"},
+		{Namespace: "mysql/innodb", Name: "row_lock_waits"},
+		{Namespace: "mysql/cmd", Name: "com_select"},
+		{Namespace: "mysql/cmd", Name: "com_insert"},
+		{Namespace: "mysql/traffic", Name: "bytes_sent"},
+	}
+	tests := []struct {
+		include  []string
+		exclude  []string
+		expected []string
+	}{
+		{nil, []string{"mysql.traffic.*"}, []string{"buffer_pool_reads", "row_lock_waits", "com_select", "com_insert"}},
+		{[]string{"mysql.cmd.*", "/^row_/"}, nil, []string{"row_lock_waits", "com_select", "com_insert"}},
+		{[]string{`/^mysql\.(innodb|cmd)\./`}, []string{"com_*"}, []string{"buffer_pool_reads", "row_lock_waits"}},
+	}
+	for i, tt := range tests {
+		f, err := newMetricFilter(tt.include, tt.exclude)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, m := range f.apply(metrics) {
+			got = append(got, m.Name)
+		}
+		if len(got) != len(tt.expected) {
+			t.Errorf("[%d] unexpected metrics expected:%v got:%v", i, tt.expected, got)
+			continue
+		}
+		for j := range got {
+			if got[j] != tt.expected[j] {
+				t.Errorf("[%d] unexpected metrics expected:%v got:%v", i, tt.expected, got)
+				break
+			}
+		}
+	}
+
+	if _, err := newMetricFilter([]string{"/[/"}, nil); err == nil {
+		t.Error("invalid regexp must be rejected")
+	}
+}
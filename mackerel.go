@@ -0,0 +1,184 @@
+package sardine
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	mackerel "github.com/mackerelio/mackerel-client-go"
+)
+
+// maxMackerelCheckMessage is the max length of a message of Mackerel check monitoring.
+const maxMackerelCheckMessage = 1024
+
+type ServiceMetric struct {
+	Service      string
+	MetricValues []*mackerel.MetricValue
+}
+
+// mackerelSink posts metrics to Mackerel as service metrics.
+type mackerelSink struct {
+	q      *queue
+	client *mackerel.Client
+	retry  *RetryConfig
+	spool  *spool
+	// w is an output of dry run.
+	w io.Writer
+}
+
+func newMackerelSink(ctx context.Context, c *Config) (Sink, error) {
+	s := &mackerelSink{
+		q:     newQueue("mackerel", 1000),
+		retry: &c.Retry,
+	}
+	if DryRun {
+		s.w = os.Stdout
+		go s.q.run(s.send, 0, nil)
+		return s, nil
+	}
+
+	s.client = mackerel.NewClient(os.Getenv("MACKEREL_APIKEY"))
+	// the client does not accept a context, so a request is bounded by max_elapsed_time of the retry.
+	if t := c.Retry.MaxElapsedTime.Duration; t > 0 && (s.client.HTTPClient.Timeout == 0 || t < s.client.HTTPClient.Timeout) {
+		s.client.HTTPClient.Timeout = t
+	}
+	var err error
+	if s.spool, err = newSpool(&c.Spool, "mackerel"); err != nil {
+		return nil, err
+	}
+	if s.spool != nil {
+		go s.q.run(s.send, s.spool.replayInterval, s.replay)
+	} else {
+		go s.q.run(s.send, 0, nil)
+	}
+	return s, nil
+}
+
+func (s *mackerelSink) Accept(ctx context.Context, b *Batch) error {
+	if b.Check != nil {
+		return s.q.put(ctx, b.Check)
+	}
+	mv := make([]*mackerel.MetricValue, 0, len(b.Metrics))
+	for _, m := range b.Metrics {
+		name := dottedMetricName(m.Namespace, m.Name)
+		if len(m.Dimensions) > 0 {
+			// Mackerel has no dimensions. the original name keeps the extracted segments.
+			name = m.RawName
+		}
+		mv = append(mv, &mackerel.MetricValue{
+			Name:  name,
+			Value: m.Value,
+			Time:  m.Timestamp.Unix(),
+		})
+	}
+	if len(mv) == 0 {
+		return nil
+	}
+	return s.q.put(ctx, ServiceMetric{
+		Service:      b.Service,
+		MetricValues: mv,
+	})
+}
+
+func (s *mackerelSink) Flush(ctx context.Context) error {
+	return s.q.flush(ctx)
+}
+
+func (s *mackerelSink) Close(ctx context.Context) error {
+	return s.q.close(ctx)
+}
+
+// send posts in to Mackerel. After ctx is cancelled, in is spooled or dropped.
+// It returns false when in is neither delivered nor spooled.
+func (s *mackerelSink) send(ctx context.Context, v interface{}) bool {
+	if c, ok := v.(*CheckReport); ok {
+		return s.sendCheckReport(ctx, c)
+	}
+	in := v.(ServiceMetric)
+	if s.w != nil {
+		printMackerel(s.w, in)
+		return true
+	}
+	if ctx.Err() != nil {
+		return spoolOrDrop(s.spool, in)
+	}
+	if Debug {
+		b, _ := json.Marshal(in)
+		log.Printf("putToMackerel: %s", b)
+	}
+	err := s.retry.Do(ctx, "PostServiceMetricValues to Mackerel", func(_ context.Context) error {
+		return s.client.PostServiceMetricValues(in.Service, in.MetricValues)
+	})
+	if err != nil {
+		log.Println("PostServiceMetricValues to Mackerel failed:", err)
+		if isRetryableError(err) || ctx.Err() != nil {
+			return spoolOrDrop(s.spool, in)
+		}
+		return false
+	}
+	return true
+}
+
+// sendCheckReport posts a check report to Mackerel check monitoring.
+// Check reports are not spooled, because stale results are not useful for check monitoring.
+func (s *mackerelSink) sendCheckReport(ctx context.Context, c *CheckReport) bool {
+	if s.w != nil {
+		printMackerelCheckReport(s.w, c)
+		return true
+	}
+	if ctx.Err() != nil {
+		return false
+	}
+	crs := newMackerelCheckReports(c)
+	if Debug {
+		b, _ := json.Marshal(crs)
+		log.Printf("putToMackerel: %s", b)
+	}
+	err := s.retry.Do(ctx, "PostCheckReports to Mackerel", func(_ context.Context) error {
+		return s.client.PostCheckReports(crs)
+	})
+	if err != nil {
+		log.Println("PostCheckReports to Mackerel failed:", err)
+		return false
+	}
+	return true
+}
+
+// mackerelCheckStatuses maps check results to statuses of Mackerel check monitoring.
+var mackerelCheckStatuses = map[CheckResult]mackerel.CheckStatus{
+	CheckOK:      mackerel.CheckStatusOK,
+	CheckFailed:  mackerel.CheckStatusCritical,
+	CheckWarning: mackerel.CheckStatusWarning,
+	CheckUnknown: mackerel.CheckStatusUnknown,
+}
+
+func newMackerelCheckReports(c *CheckReport) *mackerel.CheckReports {
+	msg := c.Message
+	if len(msg) > maxMackerelCheckMessage {
+		msg = strings.ToValidUTF8(msg[:maxMackerelCheckMessage], "")
+	}
+	return &mackerel.CheckReports{
+		Reports: []*mackerel.CheckReport{
+			{
+				Source:     mackerel.NewCheckSourceHost(c.HostID),
+				Name:       c.Name,
+				Status:     mackerelCheckStatuses[c.Result],
+				Message:    msg,
+				OccurredAt: c.Timestamp.Unix(),
+			},
+		},
+	}
+}
+
+func (s *mackerelSink) replay(ctx context.Context) {
+	s.spool.Replay(ctx, func(b []byte) error {
+		var in ServiceMetric
+		if err := json.Unmarshal(b, &in); err != nil {
+			return &permanentError{err}
+		}
+		return s.client.PostServiceMetricValues(in.Service, in.MetricValues)
+	})
+}
@@ -0,0 +1,127 @@
+package sardine
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	mackerel "github.com/mackerelio/mackerel-client-go"
+)
+
+func TestMackerelSinkSendResult(t *testing.T) {
+	var status int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		w.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+	client, err := mackerel.NewClientWithOptions("dummy", srv.URL, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	retry := &RetryConfig{MaxAttempts: 2, InitialInterval: duration{time.Millisecond}}
+	if err := retry.setDefaults(); err != nil {
+		t.Fatal(err)
+	}
+	s := &mackerelSink{client: client, retry: retry}
+	in := ServiceMetric{
+		Service:      "test",
+		MetricValues: []*mackerel.MetricValue{{Name: "test.metric", Value: 1.0, Time: time.Now().Unix()}},
+	}
+	tests := []struct {
+		status   int
+		expected bool
+	}{
+		{http.StatusOK, true},
+		{http.StatusBadRequest, false},
+		{http.StatusServiceUnavailable, false}, // retries are exhausted without the spool
+	}
+	for _, tt := range tests {
+		status = tt.status
+		if got := s.send(context.Background(), in); got != tt.expected {
+			t.Errorf("unexpected result of send on status %d expected:%t got:%t", tt.status, tt.expected, got)
+		}
+	}
+}
+
+func TestMackerelSinkCheckUnknown(t *testing.T) {
+	type report struct {
+		Source struct {
+			HostID string `json:"hostId"`
+		} `json:"source"`
+		Name    string               `json:"name"`
+		Status  mackerel.CheckStatus `json:"status"`
+		Message string               `json:"message"`
+	}
+	var mu sync.Mutex
+	var reports []*report
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var crs struct {
+			Reports []*report `json:"reports"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&crs); err != nil {
+			t.Error(err)
+		}
+		mu.Lock()
+		reports = append(reports, crs.Reports...)
+		mu.Unlock()
+		w.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+	client, err := mackerel.NewClientWithOptions("dummy", srv.URL, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &mackerelSink{q: newQueue("mackerel", 10), client: client, retry: &RetryConfig{}}
+	if err := s.retry.setDefaults(); err != nil {
+		t.Fatal(err)
+	}
+	go s.q.run(s.send, 0, nil)
+
+	conf, err := parseConfig([]byte(`
+[plugin.check.exit3]
+command     = "sh -c 'echo broken; exit 3'"
+namespace   = "test/check"
+destination = "mackerel"
+host_id     = "test-host"
+
+[plugin.check.timeout]
+command     = "sleep 10"
+timeout     = "100ms"
+namespace   = "test/check"
+destination = "mackerel"
+host_id     = "test-host"
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	for _, id := range sortedKeys(conf.CheckPlugins) {
+		cp := conf.CheckPlugins[id]
+		cp.Sink = s
+		if err := cp.RunAtOnce(ctx); err == nil {
+			t.Errorf("[%s] the failure of the execution must be returned", id)
+		}
+	}
+	if err := s.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(reports) != 2 {
+		t.Fatalf("unexpected reports %d", len(reports))
+	}
+	expected := map[string]string{
+		"exit3":   "command execute failed with exit code 3: broken",
+		"timeout": "command execute timed out",
+	}
+	for _, r := range reports {
+		if r.Source.HostID != "test-host" || r.Status != mackerel.CheckStatusUnknown || r.Message != expected[r.Name] {
+			t.Errorf("unexpected report of %s: %s %s %q", r.Name, r.Source.HostID, r.Status, r.Message)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package sardine
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/Songmu/timeout"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
+)
+
+// mackerelPluginMetaHeader is the first line of the meta output of Mackerel plugins.
+const mackerelPluginMetaHeader = "# mackerel-agent-plugin"
+
+// mackerelUnits maps units of Mackerel graphs to CloudWatch units.
+var mackerelUnits = map[string]types.StandardUnit{
+	"percentage":   types.StandardUnitPercent,
+	"seconds":      types.StandardUnitSeconds,
+	"milliseconds": types.StandardUnitMilliseconds,
+	"bytes":        types.StandardUnitBytes,
+	"bytes/sec":    types.StandardUnitBytesSecond,
+	"bits/sec":     types.StandardUnitBitsSecond,
+	"iops":         types.StandardUnitCountSecond,
+}
+
+// pluginMeta is graph definitions which a Mackerel plugin prints with MACKEREL_AGENT_PLUGIN_META=1.
+type pluginMeta struct {
+	Graphs map[string]*pluginGraphDef `json:"graphs"`
+}
+
+type pluginGraphDef struct {
+	Unit    string                  `json:"unit"`
+	Metrics []*pluginGraphDefMetric `json:"metrics"`
+}
+
+type pluginGraphDefMetric struct {
+	Name string `json:"name"`
+}
+
+// units returns units of metric name patterns in the graphs. "#" (a wildcard of Mackerel) is replaced by "*".
+func (pm *pluginMeta) units() map[string]types.StandardUnit {
+	units := make(map[string]types.StandardUnit)
+	for key, g := range pm.Graphs {
+		u, ok := mackerelUnits[g.Unit]
+		if !ok {
+			continue
+		}
+		for _, m := range g.Metrics {
+			units[strings.ReplaceAll(key+"."+m.Name, "#", "*")] = u
+		}
+	}
+	return units
+}
+
+// loadMeta queries the meta of the plugin unless plugin_meta is disabled, and applies it to the units.
+// Commands which are not Mackerel plugins are ignored.
+func (mp *CommandMetricPlugin) loadMeta() {
+	if !mp.pluginMeta {
+		return
+	}
+	meta, err := executeMetaCommand(mp)
+	if err != nil {
+		log.Printf("[%s] failed to get plugin meta: %s", mp.id, err)
+		return
+	}
+	if meta == nil {
+		return
+	}
+	mp.metaUnits = meta.units()
+}
+
+// executeMetaCommand executes the command with MACKEREL_AGENT_PLUGIN_META=1.
+// It returns nil when the output is not the meta of a Mackerel plugin.
+func executeMetaCommand(mp MetricPlugin) (*pluginMeta, error) {
+	args := mp.Command()
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Env = append(os.Environ(), "MACKEREL_AGENT_PLUGIN_META=1")
+	tio := &timeout.Timeout{
+		Duration:  mp.Timeout(),
+		KillAfter: 5 * time.Second,
+		Cmd:       cmd,
+	}
+	status, stdout, stderr, err := tio.Run()
+	if len(stderr) > 0 {
+		log.Printf("[%s] %s", mp.ID(), stderr)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("command execute failed with exit code %d: %w", status.GetExitCode(), err)
+	}
+	if status.IsTimedOut() || status.IsKilled() {
+		return nil, fmt.Errorf("command execute timed out")
+	}
+	return parsePluginMeta(stdout)
+}
+
+func parsePluginMeta(s string) (*pluginMeta, error) {
+	r := bufio.NewReader(strings.NewReader(s))
+	header, _ := r.ReadString('\n')
+	if strings.TrimSpace(header) != mackerelPluginMetaHeader {
+		return nil, nil
+	}
+	var meta pluginMeta
+	if err := json.NewDecoder(r).Decode(&meta); err != nil {
+		return nil, fmt.Errorf("invalid plugin meta: %w", err)
+	}
+	return &meta, nil
+}
@@ -0,0 +1,69 @@
+package sardine
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
+)
+
+const testPluginScript = `#!/bin/sh
+if [ "$MACKEREL_AGENT_PLUGIN_META" = "1" ]; then
+  echo "# mackerel-agent-plugin"
+  echo '{"graphs":{"memcached.bytes":{"label":"Memcached Bytes","unit":"bytes","metrics":[{"name":"*","label":"%1","stacked":true}]},"memcached.cmd":{"label":"Memcached Command","unit":"integer","metrics":[{"name":"cmd_get","label":"Get"}]}}}'
+  exit 0
+fi
+echo "memcached.bytes.used	1024	1512057958"
+`
+
+func TestLoadPluginMeta(t *testing.T) {
+	script := filepath.Join(t.TempDir(), "plugin.sh")
+	if err := os.WriteFile(script, []byte(testPluginScript), 0755); err != nil {
+		t.Fatal(err)
+	}
+	conf, err := parseConfig([]byte(`
+[plugin.metrics.memcached]
+command = "` + script + `"
+
+[plugin.metrics.disabled]
+command     = "` + script + `"
+plugin_meta = false
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mp := conf.MetricPlugins["memcached"].(*CommandMetricPlugin)
+	loadPluginMeta(mp)
+
+	metrics, err := executeCommand(context.Background(), mp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(metrics) != 1 {
+		t.Fatalf("unexpected metrics %d", len(metrics))
+	}
+	if u := mp.unitOf(metrics[0]); u != types.StandardUnitBytes {
+		t.Errorf("unexpected unit %s", u)
+	}
+	if u := mp.unitOf(&Metric{RawName: "memcached.cmd.cmd_get", Name: "cmd_get"}); u != "" {
+		t.Errorf("unexpected unit of integer %s", u)
+	}
+
+	disabled := conf.MetricPlugins["disabled"].(*CommandMetricPlugin)
+	loadPluginMeta(disabled)
+	if u := disabled.unitOf(metrics[0]); u != "" {
+		t.Errorf("plugin meta must not be queried with plugin_meta = false. unit %s", u)
+	}
+}
+
+func TestParsePluginMetaNotMackerelPlugin(t *testing.T) {
+	meta, err := parsePluginMeta("memcached.bytes.used\t1024\t1512057958\n")
+	if meta != nil || err != nil {
+		t.Errorf("unexpected meta %v %v", meta, err)
+	}
+	if _, err := parsePluginMeta("# mackerel-agent-plugin\n{"); err == nil {
+		t.Error("broken meta must be an error")
+	}
+}
@@ -6,16 +6,14 @@ import (
 	"fmt"
 	"log"
 	"os/exec"
+	"path"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/Songmu/timeout"
-	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
 	"github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
-	mackerel "github.com/mackerelio/mackerel-client-go"
 )
 
 type MetricPlugin interface {
@@ -23,7 +21,7 @@ type MetricPlugin interface {
 	Command() []string
 	Timeout() time.Duration
 	Interval() time.Duration
-	Enqueue([]*Metric)
+	Enqueue(context.Context, []*Metric) error
 	ParseMetricLine(string) (*Metric, error)
 }
 
@@ -32,6 +30,12 @@ type Metric struct {
 	Name      string
 	Value     float64
 	Timestamp time.Time
+	// Unit is a CloudWatch unit of the metric. e.g. Bytes
+	Unit string
+	// RawName is the metric name in the command output. e.g. memcached.cmd.cmd_get
+	RawName string
+	// Dimensions are extracted from the metric name. They are added to the dimensions of the plugin.
+	Dimensions []types.Dimension
 }
 
 func (m *Metric) NewMetricDatum(ds []types.Dimension) types.MetricDatum {
@@ -39,148 +43,155 @@ func (m *Metric) NewMetricDatum(ds []types.Dimension) types.MetricDatum {
 		MetricName: &m.Name,
 		Value:      &m.Value,
 		Timestamp:  &m.Timestamp,
-		Dimensions: ds,
+		Dimensions: mergeDimensions(ds, m.Dimensions),
+		Unit:       types.StandardUnit(m.Unit),
 	}
 }
 
-type ServiceMetric struct {
-	Service      string
-	MetricValues []*mackerel.MetricValue
+// CommandMetricPlugin runs a command, and sends metrics printed by the command to the sinks of the destinations.
+type CommandMetricPlugin struct {
+	id           string
+	command      []string
+	timeout      time.Duration
+	interval     time.Duration
+	Destinations []*Destination
+	// StorageResolution is a storage resolution of CloudWatch metrics. 1 (high resolution) or 60.
+	StorageResolution int32
+	// AggregationWindow is a window to aggregate metrics into CloudWatch statistic sets. 0 disables aggregation.
+	AggregationWindow time.Duration
+	// Unit is a default unit of metrics.
+	Unit types.StandardUnit
+	// Units maps metric name patterns to units.
+	Units map[string]types.StandardUnit
+
+	naming      *metricNaming
+	dimensions  []*dimensionRule
+	transformer *metricTransformer
+	// pluginMeta enables querying the meta of the Mackerel plugin. It is enabled by default.
+	pluginMeta bool
+	// metaUnits maps metric name patterns to units in the meta of the Mackerel plugin.
+	metaUnits map[string]types.StandardUnit
+	filter    *metricFilter
+	rates     *rateCalculator
 }
 
-type CloudWatchMetricPlugin struct {
-	id         string
-	command    []string
-	timeout    time.Duration
-	interval   time.Duration
+// CloudWatchMetricPlugin is the former metric plugin for the destination cloudwatch.
+//
+// Deprecated: Use CommandMetricPlugin. Dimensions and Ch are replaced by Destinations and their sinks.
+type CloudWatchMetricPlugin = CommandMetricPlugin
+
+// MackerelMetricPlugin is the former metric plugin for the destination mackerel.
+//
+// Deprecated: Use CommandMetricPlugin. Service and Ch are replaced by Destinations and their sinks.
+type MackerelMetricPlugin = CommandMetricPlugin
+
+// Destination represents a destination of a metric plugin with its options.
+type Destination struct {
+	Name       string
 	Dimensions [][]types.Dimension
-	Ch         chan *cloudwatch.PutMetricDataInput
+	Service    string
+	Sink       Sink
 }
 
-func (mp *CloudWatchMetricPlugin) ID() string {
+func (mp *CommandMetricPlugin) ID() string {
 	return mp.id
 }
 
-func (mp *CloudWatchMetricPlugin) Command() []string {
+func (mp *CommandMetricPlugin) Command() []string {
 	return mp.command
 }
 
-func (mp *CloudWatchMetricPlugin) Timeout() time.Duration {
+func (mp *CommandMetricPlugin) Timeout() time.Duration {
 	return mp.timeout
 }
 
-func (mp *CloudWatchMetricPlugin) Interval() time.Duration {
+func (mp *CommandMetricPlugin) Interval() time.Duration {
 	return mp.interval
 }
 
-func (mp *CloudWatchMetricPlugin) Enqueue(metrics []*Metric) {
-	mds := make(map[string][]types.MetricDatum, len(mp.Dimensions)+1)
-	for _, metric := range metrics {
-		ns := metric.Namespace
-		for _, ds := range mp.Dimensions {
-			mds[ns] = append(mds[ns], metric.NewMetricDatum(ds))
-		}
-		// no dimension metric
-		mds[ns] = append(mds[ns], metric.NewMetricDatum(nil))
+// Enqueue sends metrics to all destinations. The metrics are shared by the batches of the destinations.
+func (mp *CommandMetricPlugin) Enqueue(ctx context.Context, metrics []*Metric) error {
+	if mp.transformer != nil {
+		metrics = mp.transformer.apply(metrics, mp.newMetric)
 	}
-	for ns, md := range mds {
-		// split MetricDatum/20
-		for i := 0; i <= len(md)/maxMetricDatum; i++ {
-			first := i * maxMetricDatum
-			last := first + maxMetricDatum
-			if last > len(md) {
-				last = len(md)
-			}
-			if len(md[first:last]) == 0 {
-				break
-			}
-			mp.Ch <- &cloudwatch.PutMetricDataInput{
-				Namespace:  aws.String(ns),
-				MetricData: md[first:last],
-			}
+	if mp.filter != nil {
+		n := len(metrics)
+		metrics = mp.filter.apply(metrics)
+		if Debug && n > len(metrics) {
+			log.Printf("[%s] %d of %d metrics dropped by filters", mp.id, n-len(metrics), n)
 		}
 	}
-}
-
-func (cmp *CloudWatchMetricPlugin) ParseMetricLine(b string) (*Metric, error) {
-	cols := strings.SplitN(b, "\t", 3)
-	if len(cols) < 3 {
-		return nil, fmt.Errorf("invalid metric format. insufficient columns")
+	if mp.rates != nil {
+		metrics = mp.rates.apply(metrics)
 	}
-	name, value, timestamp := cols[0], cols[1], cols[2]
-	var m Metric
-
-	ns := strings.SplitN(name, ".", 3)
-	if len(ns) != 3 {
-		return nil, fmt.Errorf("invalid metric name: %s", name)
-	} else {
-		m.Namespace = ns[0] + "/" + ns[1]
-		m.Name = ns[2]
+	if len(metrics) == 0 {
+		return nil
 	}
-
-	if v, err := strconv.ParseFloat(value, 64); err != nil {
-		return nil, fmt.Errorf("invalid metric value: %s", value)
-	} else {
-		m.Value = v
+	for _, m := range metrics {
+		m.Unit = string(mp.unitOf(m))
 	}
-
-	if ts, err := strconv.ParseInt(timestamp, 10, 64); err != nil {
-		return nil, fmt.Errorf("invalid metric time: %s", timestamp)
-	} else {
-		m.Timestamp = time.Unix(ts, 0)
+	var errs []string
+	for _, d := range mp.Destinations {
+		err := d.Sink.Accept(ctx, &Batch{
+			PluginID:          mp.id,
+			Metrics:           metrics,
+			Dimensions:        d.Dimensions,
+			Service:           d.Service,
+			StorageResolution: mp.StorageResolution,
+			AggregationWindow: mp.AggregationWindow,
+		})
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %s", d.Name, err))
+		}
 	}
-
-	return &m, nil
-}
-
-type MackerelMetricPlugin struct {
-	id       string
-	command  []string
-	timeout  time.Duration
-	interval time.Duration
-	Service  string
-	Ch       chan ServiceMetric
-}
-
-func (mp *MackerelMetricPlugin) ID() string {
-	return mp.id
-}
-
-func (mp *MackerelMetricPlugin) Command() []string {
-	return mp.command
-}
-
-func (mp *MackerelMetricPlugin) Timeout() time.Duration {
-	return mp.timeout
-}
-
-func (mp *MackerelMetricPlugin) Interval() time.Duration {
-	return mp.interval
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to enqueue metrics. %s", strings.Join(errs, ", "))
+	}
+	return nil
 }
 
-func (mp *MackerelMetricPlugin) Enqueue(metrics []*Metric) {
-	mv := []*mackerel.MetricValue{}
-	for _, m := range metrics {
-		mv = append(mv, &mackerel.MetricValue{
-			Name:  m.Name,
-			Value: m.Value,
-			Time:  m.Timestamp.Unix(),
-		})
+// unitOf returns the unit of the metric.
+func (mp *CommandMetricPlugin) unitOf(m *Metric) types.StandardUnit {
+	if u, ok := matchMetricPattern(mp.Units, m); ok {
+		return u
 	}
+	if u, ok := matchMetricPattern(mp.metaUnits, m); ok {
+		return u
+	}
+	return mp.Unit
+}
 
-	mp.Ch <- ServiceMetric{
-		Service:      mp.Service,
-		MetricValues: mv,
+// matchMetricPattern returns the value of the pattern which matches the metric.
+// Patterns are matched with the metric name in the command output (e.g. memcached.cmd.bytes) and the name without the namespace (e.g. bytes).
+// When multiple patterns match, the longest pattern wins.
+func matchMetricPattern[T any](rules map[string]T, m *Metric) (T, bool) {
+	var matched string
+	full := m.fullName()
+	for pattern := range rules {
+		if len(pattern) < len(matched) || len(pattern) == len(matched) && pattern > matched {
+			continue
+		}
+		if ok, _ := path.Match(pattern, full); ok {
+			matched = pattern
+		} else if ok, _ := path.Match(pattern, m.Name); ok {
+			matched = pattern
+		}
 	}
+	v, ok := rules[matched]
+	return v, ok && matched != ""
 }
 
-func (mp *MackerelMetricPlugin) ParseMetricLine(b string) (*Metric, error) {
+// ParseMetricLine parses a metric line.
+// By default, when the metric name has three or more segments, the first two segments become the namespace, and the rest becomes the name.
+// Otherwise, the namespace is empty and the whole metric name becomes the name.
+// Dimensions are extracted from the metric name, and then the namespace and the name can be rewritten by the config of the plugin.
+func (mp *CommandMetricPlugin) ParseMetricLine(b string) (*Metric, error) {
 	cols := strings.SplitN(b, "\t", 3)
 	if len(cols) < 3 {
 		return nil, fmt.Errorf("invalid metric format. insufficient columns")
 	}
 	name, value, timestamp := cols[0], cols[1], cols[2]
-	m := Metric{Name: name}
+	m := mp.newMetric(name)
 
 	if v, err := strconv.ParseFloat(value, 64); err != nil {
 		return nil, fmt.Errorf("invalid metric value: %s", value)
@@ -194,13 +205,42 @@ func (mp *MackerelMetricPlugin) ParseMetricLine(b string) (*Metric, error) {
 		m.Timestamp = time.Unix(ts, 0)
 	}
 
-	return &m, nil
+	return m, nil
+}
+
+// newMetric returns a metric which has the namespace, the name and the dimensions of the metric name in the command output.
+func (mp *CommandMetricPlugin) newMetric(name string) *Metric {
+	m := &Metric{RawName: name}
+	naming := mp.naming
+	if naming == nil {
+		naming = &metricNaming{segments: DefaultNamespaceSegments}
+	}
+	name, m.Dimensions = extractDimensions(mp.dimensions, name)
+	m.Namespace, m.Name = naming.split(name)
+	return m
+}
+
+// fullName returns the metric name in the command output.
+func (m *Metric) fullName() string {
+	if m.RawName != "" {
+		return m.RawName
+	}
+	return dottedMetricName(m.Namespace, m.Name)
+}
+
+// dottedMetricName returns a dot-separated metric name. e.g. memcached/cmd, cmd_get -> memcached.cmd.cmd_get
+func dottedMetricName(namespace, name string) string {
+	if namespace == "" {
+		return name
+	}
+	return strings.ReplaceAll(namespace, "/", ".") + "." + name
 }
 
 func runMetricPlugin(ctx context.Context, wg *sync.WaitGroup, mp MetricPlugin) {
 	defer wg.Done()
 	ticker := time.NewTicker(mp.Interval())
 	log.Printf("[%s] starting", mp.ID())
+	loadPluginMeta(mp)
 	for {
 		if err := runMetricPluginAtOnce(ctx, mp); err != nil {
 			log.Println(err)
@@ -214,12 +254,21 @@ func runMetricPlugin(ctx context.Context, wg *sync.WaitGroup, mp MetricPlugin) {
 	}
 }
 
+// loadPluginMeta loads the meta of the plugin if the plugin supports it.
+func loadPluginMeta(mp MetricPlugin) {
+	if cmp, ok := mp.(*CommandMetricPlugin); ok {
+		cmp.loadMeta()
+	}
+}
+
 func runMetricPluginAtOnce(ctx context.Context, mp MetricPlugin) error {
 	metrics, err := executeCommand(ctx, mp)
 	if err != nil {
 		return fmt.Errorf("[%s] %w", mp.ID(), err)
 	}
-	mp.Enqueue(metrics)
+	if err := mp.Enqueue(ctx, metrics); err != nil {
+		return fmt.Errorf("[%s] %w", mp.ID(), err)
+	}
 	return nil
 }
 
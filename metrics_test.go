@@ -0,0 +1,38 @@
+package sardine
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
+)
+
+func TestMetricUnit(t *testing.T) {
+	conf, err := parseConfig([]byte(`
+[plugin.metrics.memcached]
+command = "true"
+unit    = "Count"
+units   = { "*.bytes" = "Bytes", "latency.*" = "Milliseconds", "*.latency.p99" = "Microseconds" }
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mp := conf.MetricPlugins["memcached"].(*CommandMetricPlugin)
+	tests := []struct {
+		line string
+		unit types.StandardUnit
+	}{
+		{"memcached.stats.bytes\t10\t1512057958", types.StandardUnitBytes},
+		{"memcached.stats.latency.p50\t10\t1512057958", types.StandardUnitMilliseconds},
+		{"memcached.stats.latency.p99\t10\t1512057958", types.StandardUnitMicroseconds},
+		{"memcached.cmd.cmd_get\t10\t1512057958", types.StandardUnitCount},
+	}
+	for _, tt := range tests {
+		m, err := mp.ParseMetricLine(tt.line)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if u := mp.unitOf(m); u != tt.unit {
+			t.Errorf("unexpected unit of %s expected:%s got:%s", tt.line, tt.unit, u)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package sardine
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var DefaultNamespaceSegments = 2
+
+// RewriteRule rewrites a metric name which matches Pattern.
+// Namespace and Name are templates which can refer capture groups of Pattern. e.g. $1, ${name}
+type RewriteRule struct {
+	Pattern   string
+	Namespace string
+	Name      string
+}
+
+type rewriteRule struct {
+	re        *regexp.Regexp
+	namespace string
+	name      string
+}
+
+// metricNaming maps a metric name in the command output to a namespace and a name.
+type metricNaming struct {
+	segments  int
+	namespace string
+	prefix    string
+	rules     []*rewriteRule
+}
+
+func newMetricNaming(pc *PluginConfig) (*metricNaming, error) {
+	n := &metricNaming{
+		segments:  DefaultNamespaceSegments,
+		namespace: pc.Namespace,
+		prefix:    pc.NamespacePrefix,
+	}
+	if pc.NamespaceSegments != nil {
+		n.segments = *pc.NamespaceSegments
+	}
+	if n.segments < 0 {
+		return nil, fmt.Errorf("namespace_segments must not be negative")
+	}
+	for _, r := range pc.Rewrite {
+		re, err := regexp.Compile(r.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %s in rewrite: %w", r.Pattern, err)
+		}
+		if r.Namespace == "" && r.Name == "" {
+			return nil, fmt.Errorf("namespace or name required for rewrite pattern %s", r.Pattern)
+		}
+		n.rules = append(n.rules, &rewriteRule{re: re, namespace: r.Namespace, name: r.Name})
+	}
+	return n, nil
+}
+
+// minSegments returns the number of segments which a metric name requires to have the namespace and the name.
+func (n *metricNaming) minSegments() int {
+	if n.namespace != "" {
+		return 1
+	}
+	return n.segments + 1
+}
+
+// split returns the namespace and the name of the metric.
+//  1. The first segments of the metric name become the namespace, and the rest becomes the name.
+//  2. A fixed namespace replaces the namespace.
+//  3. The first rewrite rule which matches the metric name replaces the namespace and the name.
+//  4. The prefix is prepended to the namespace.
+func (n *metricNaming) split(raw string) (string, string) {
+	var ns, name string
+	if segs := strings.SplitN(raw, ".", n.segments+1); len(segs) == n.segments+1 {
+		ns, name = strings.Join(segs[:n.segments], "/"), segs[n.segments]
+	} else {
+		name = raw
+	}
+	if n.namespace != "" {
+		ns = n.namespace
+	}
+	for _, r := range n.rules {
+		idx := r.re.FindStringSubmatchIndex(raw)
+		if idx == nil {
+			continue
+		}
+		if r.namespace != "" {
+			ns = string(r.re.ExpandString(nil, r.namespace, raw, idx))
+		}
+		if r.name != "" {
+			name = string(r.re.ExpandString(nil, r.name, raw, idx))
+		}
+		break
+	}
+	if n.prefix != "" {
+		if ns == "" {
+			ns = strings.TrimSuffix(n.prefix, "/")
+		} else {
+			ns = n.prefix + ns
+		}
+	}
+	return ns, name
+}
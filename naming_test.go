@@ -0,0 +1,61 @@
+package sardine
+
+import (
+	"testing"
+)
+
+func TestMetricNaming(t *testing.T) {
+	conf, err := parseConfig([]byte(`
+[plugin.metrics.default]
+command = "true"
+
+[plugin.metrics.segments]
+command            = "true"
+namespace_segments = 1
+namespace_prefix   = "MyApp/"
+
+[plugin.metrics.fixed]
+command            = "true"
+namespace          = "MyApp/Memcached"
+namespace_segments = 0
+
+[plugin.metrics.rewrite]
+command          = "true"
+namespace_prefix = "MyApp/"
+[[plugin.metrics.rewrite.rewrite]]
+pattern   = '^memcached\.cmd\.cmd_(\w+)$'
+namespace = "Memcached/Commands"
+name      = "${1}_count"
+[[plugin.metrics.rewrite.rewrite]]
+pattern = '^memcached\.(\w+)\.(.+)$'
+name    = "${1}_$2"
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		plugin    string
+		name      string
+		namespace string
+		metric    string
+	}{
+		{"default", "memcached.cmd.cmd_get", "memcached/cmd", "cmd_get"},
+		{"default", "uptime", "", "uptime"},
+		{"segments", "memcached.cmd.cmd_get", "MyApp/memcached", "cmd.cmd_get"},
+		{"segments", "uptime", "MyApp", "uptime"},
+		{"fixed", "memcached.cmd.cmd_get", "MyApp/Memcached", "memcached.cmd.cmd_get"},
+		{"rewrite", "memcached.cmd.cmd_get", "MyApp/Memcached/Commands", "get_count"},
+		{"rewrite", "memcached.stats.bytes", "MyApp/memcached/stats", "stats_bytes"},
+		{"rewrite", "uptime", "MyApp", "uptime"},
+	}
+	for _, tt := range tests {
+		mp := conf.MetricPlugins[tt.plugin].(*CommandMetricPlugin)
+		m, err := mp.ParseMetricLine(tt.name + "\t1\t1512057958")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m.Namespace != tt.namespace || m.Name != tt.metric || m.RawName != tt.name {
+			t.Errorf("[%s] unexpected metric of %s expected:%s %s got:%s %s", tt.plugin, tt.name, tt.namespace, tt.metric, m.Namespace, m.Name)
+		}
+	}
+}
@@ -0,0 +1,291 @@
+package sardine
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+var (
+	DefaultOTLPHTTPEndpoint = "http://localhost:4318"
+	DefaultOTLPGRPCEndpoint = "localhost:4317"
+	DefaultOTLPTimeout      = 10 * time.Second
+)
+
+// OTLPConfig represents a config of the OpenTelemetry collector to export metrics.
+type OTLPConfig struct {
+	Endpoint string
+	Protocol string
+	Headers  map[string]string
+	Insecure bool
+	Timeout  duration
+}
+
+func (oc *OTLPConfig) setDefaults() error {
+	switch oc.Protocol {
+	case "http/protobuf", "":
+		oc.Protocol = "http/protobuf"
+		if oc.Endpoint == "" {
+			oc.Endpoint = DefaultOTLPHTTPEndpoint
+		}
+	case "grpc":
+		if oc.Endpoint == "" {
+			oc.Endpoint = DefaultOTLPGRPCEndpoint
+		}
+	default:
+		return fmt.Errorf("[otlp] protocol %s is not allowed. use http/protobuf or grpc", oc.Protocol)
+	}
+	if oc.Timeout.Duration == 0 {
+		oc.Timeout.Duration = DefaultOTLPTimeout
+	}
+	return nil
+}
+
+// otlpSink exports metrics to the OpenTelemetry collector.
+type otlpSink struct {
+	q        *queue
+	exporter otlpExporter
+	retry    *RetryConfig
+	// w is an output of dry run.
+	w io.Writer
+}
+
+func newOTLPSink(ctx context.Context, c *Config) (Sink, error) {
+	s := &otlpSink{
+		q:     newQueue("otlp", 1000),
+		retry: &c.Retry,
+	}
+	if DryRun {
+		s.w = os.Stdout
+	} else {
+		var err error
+		if s.exporter, err = newOTLPExporter(&c.OTLP); err != nil {
+			return nil, err
+		}
+	}
+	go s.q.run(s.send, 0, nil)
+	return s, nil
+}
+
+func (s *otlpSink) Accept(ctx context.Context, b *Batch) error {
+	ms := make([]*metricspb.Metric, 0, len(b.Metrics))
+	for _, m := range b.Metrics {
+		ts := uint64(m.Timestamp.UnixNano())
+		var dps []*metricspb.NumberDataPoint
+		if len(b.Dimensions) == 0 {
+			dps = append(dps, newOTLPDataPoint(m.Dimensions, m.Value, ts))
+		}
+		for _, ds := range b.Dimensions {
+			dps = append(dps, newOTLPDataPoint(mergeDimensions(ds, m.Dimensions), m.Value, ts))
+		}
+		ms = append(ms, &metricspb.Metric{
+			Name: dottedMetricName(m.Namespace, m.Name),
+			Data: &metricspb.Metric_Gauge{
+				Gauge: &metricspb.Gauge{DataPoints: dps},
+			},
+		})
+	}
+	if len(ms) == 0 {
+		return nil
+	}
+	return s.q.put(ctx, &metricspb.ResourceMetrics{
+		Resource: &resourcepb.Resource{
+			Attributes: []*commonpb.KeyValue{otlpStringAttribute("service.name", "sardine")},
+		},
+		ScopeMetrics: []*metricspb.ScopeMetrics{
+			{
+				Scope: &commonpb.InstrumentationScope{
+					Name:       "sardine",
+					Attributes: []*commonpb.KeyValue{otlpStringAttribute("sardine.plugin.id", b.PluginID)},
+				},
+				Metrics: ms,
+			},
+		},
+	})
+}
+
+func (s *otlpSink) Flush(ctx context.Context) error {
+	return s.q.flush(ctx)
+}
+
+func (s *otlpSink) Close(ctx context.Context) error {
+	err := s.q.close(ctx)
+	if s.exporter != nil {
+		s.exporter.Close()
+	}
+	return err
+}
+
+// send exports in to the OpenTelemetry collector. After ctx is cancelled, in is dropped.
+// It returns false when in is not delivered.
+func (s *otlpSink) send(ctx context.Context, v interface{}) bool {
+	in := v.(*metricspb.ResourceMetrics)
+	if s.w != nil {
+		printOTLP(s.w, in)
+		return true
+	}
+	if ctx.Err() != nil {
+		return false
+	}
+	req := &colmetricspb.ExportMetricsServiceRequest{
+		ResourceMetrics: []*metricspb.ResourceMetrics{in},
+	}
+	if Debug {
+		log.Printf("putToOTLP: %s", in)
+	}
+	err := s.retry.Do(ctx, "Export to OTLP", func(ctx context.Context) error {
+		return s.exporter.Export(ctx, req)
+	})
+	if err != nil {
+		log.Println("Export to OTLP failed:", err)
+		return false
+	}
+	return true
+}
+
+func newOTLPDataPoint(ds []types.Dimension, value float64, ts uint64) *metricspb.NumberDataPoint {
+	attrs := make([]*commonpb.KeyValue, 0, len(ds))
+	for _, d := range ds {
+		attrs = append(attrs, otlpStringAttribute(aws.ToString(d.Name), aws.ToString(d.Value)))
+	}
+	return &metricspb.NumberDataPoint{
+		Attributes:   attrs,
+		TimeUnixNano: ts,
+		Value:        &metricspb.NumberDataPoint_AsDouble{AsDouble: value},
+	}
+}
+
+func otlpStringAttribute(key, value string) *commonpb.KeyValue {
+	return &commonpb.KeyValue{
+		Key:   key,
+		Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: value}},
+	}
+}
+
+type otlpExporter interface {
+	Export(context.Context, *colmetricspb.ExportMetricsServiceRequest) error
+	Close() error
+}
+
+func newOTLPExporter(oc *OTLPConfig) (otlpExporter, error) {
+	switch oc.Protocol {
+	case "grpc":
+		var creds credentials.TransportCredentials
+		if oc.Insecure {
+			creds = insecure.NewCredentials()
+		} else {
+			creds = credentials.NewTLS(&tls.Config{})
+		}
+		conn, err := grpc.Dial(oc.Endpoint, grpc.WithTransportCredentials(creds))
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to otlp endpoint %s: %w", oc.Endpoint, err)
+		}
+		return &otlpGRPCExporter{
+			conn:    conn,
+			client:  colmetricspb.NewMetricsServiceClient(conn),
+			headers: metadata.New(oc.Headers),
+			timeout: oc.Timeout.Duration,
+		}, nil
+	default:
+		return &otlpHTTPExporter{
+			url:     strings.TrimSuffix(oc.Endpoint, "/") + "/v1/metrics",
+			headers: oc.Headers,
+			client:  &http.Client{Timeout: oc.Timeout.Duration},
+		}, nil
+	}
+}
+
+type otlpHTTPError struct {
+	statusCode int
+	body       string
+}
+
+func (e *otlpHTTPError) Error() string {
+	return fmt.Sprintf("unexpected response status %d: %s", e.statusCode, e.body)
+}
+
+func (e *otlpHTTPError) HTTPStatusCode() int {
+	return e.statusCode
+}
+
+type otlpHTTPExporter struct {
+	url     string
+	headers map[string]string
+	client  *http.Client
+}
+
+func (e *otlpHTTPExporter) Export(ctx context.Context, in *colmetricspb.ExportMetricsServiceRequest) error {
+	b, err := proto.Marshal(in)
+	if err != nil {
+		return &permanentError{err}
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.url, bytes.NewReader(b))
+	if err != nil {
+		return &permanentError{err}
+	}
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	for k, v := range e.headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	if resp.StatusCode/100 != 2 {
+		return &otlpHTTPError{statusCode: resp.StatusCode, body: string(body)}
+	}
+	return nil
+}
+
+func (e *otlpHTTPExporter) Close() error {
+	return nil
+}
+
+type otlpGRPCExporter struct {
+	conn    *grpc.ClientConn
+	client  colmetricspb.MetricsServiceClient
+	headers metadata.MD
+	timeout time.Duration
+}
+
+func (e *otlpGRPCExporter) Export(ctx context.Context, in *colmetricspb.ExportMetricsServiceRequest) error {
+	ctx, cancel := context.WithTimeout(metadata.NewOutgoingContext(ctx, e.headers), e.timeout)
+	defer cancel()
+	_, err := e.client.Export(ctx, in)
+	if err == nil {
+		return nil
+	}
+	switch status.Code(err) {
+	case codes.Unavailable, codes.ResourceExhausted, codes.DeadlineExceeded, codes.Aborted:
+		return err
+	default:
+		return &permanentError{err}
+	}
+}
+
+func (e *otlpGRPCExporter) Close() error {
+	return e.conn.Close()
+}
@@ -0,0 +1,85 @@
+package sardine
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestOTLPHTTPExporter(t *testing.T) {
+	received := make(chan *colmetricspb.ExportMetricsServiceRequest, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/metrics" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		if ct := r.Header.Get("Content-Type"); ct != "application/x-protobuf" {
+			t.Errorf("unexpected content-type %s", ct)
+		}
+		if v := r.Header.Get("X-Test"); v != "foo" {
+			t.Errorf("unexpected header %s", v)
+		}
+		b, _ := io.ReadAll(r.Body)
+		var req colmetricspb.ExportMetricsServiceRequest
+		if err := proto.Unmarshal(b, &req); err != nil {
+			t.Error(err)
+		}
+		received <- &req
+	}))
+	defer ts.Close()
+
+	conf, err := parseConfig([]byte(`
+[otlp]
+endpoint = "` + ts.URL + `"
+headers  = { "X-Test" = "foo" }
+
+[plugin.metrics.memcached]
+command     = "true"
+destination = "otlp"
+dimensions  = ["Host=127.0.0.1"]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	sink, err := newOTLPSink(ctx, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mp := conf.MetricPlugins["memcached"].(*CommandMetricPlugin)
+	mp.Destinations[0].Sink = sink
+	m, err := mp.ParseMetricLine("memcached.cmd.cmd_get\t10\t1512057958")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := mp.Enqueue(ctx, []*Metric{m}); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Close(ctx); err != nil {
+		t.Error(err)
+	}
+
+	req := <-received
+	sm := req.ResourceMetrics[0].ScopeMetrics[0]
+	if id := sm.Scope.Attributes[0].Value.GetStringValue(); id != "plugin.metrics.memcached" {
+		t.Errorf("unexpected plugin id %s", id)
+	}
+	metric := sm.Metrics[0]
+	if metric.Name != "memcached.cmd.cmd_get" {
+		t.Errorf("unexpected metric name %s", metric.Name)
+	}
+	dp := metric.GetGauge().DataPoints[0]
+	if dp.GetAsDouble() != 10 {
+		t.Errorf("unexpected value %f", dp.GetAsDouble())
+	}
+	if dp.TimeUnixNano != 1512057958*1e9 {
+		t.Errorf("unexpected time %d", dp.TimeUnixNano)
+	}
+	if k, v := dp.Attributes[0].Key, dp.Attributes[0].Value.GetStringValue(); k != "Host" || v != "127.0.0.1" {
+		t.Errorf("unexpected attribute %s=%s", k, v)
+	}
+}
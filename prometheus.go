@@ -0,0 +1,203 @@
+package sardine
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
+)
+
+var DefaultPrometheusPath = "/metrics"
+
+// PrometheusConfig represents a config of the Prometheus exposition endpoint.
+type PrometheusConfig struct {
+	Listen string
+	Path   string
+}
+
+func (pc *PrometheusConfig) setDefaults() {
+	if pc.Path == "" {
+		pc.Path = DefaultPrometheusPath
+	}
+}
+
+// prometheusSink keeps the latest metrics in a registry, and serves them in the Prometheus exposition format.
+type prometheusSink struct {
+	registry *prometheusRegistry
+	srv      *http.Server
+	// w is an output of the exposition on close, in dry run without listen.
+	w io.Writer
+}
+
+func newPrometheusSink(ctx context.Context, c *Config) (Sink, error) {
+	s := &prometheusSink{
+		registry: newPrometheusRegistry(),
+	}
+	pc := &c.Prometheus
+	if pc.Listen == "" {
+		if DryRun {
+			s.w = os.Stdout
+		}
+		return s, nil
+	}
+	ln, err := net.Listen("tcp", pc.Listen)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen prometheus endpoint: %w", err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle(pc.Path, s.registry)
+	s.srv = &http.Server{Handler: mux}
+	log.Printf("serving prometheus metrics on %s%s", pc.Listen, pc.Path)
+	go func() {
+		if err := s.srv.Serve(ln); err != nil && err != http.ErrServerClosed {
+			log.Println("prometheus server failed:", err)
+		}
+	}()
+	return s, nil
+}
+
+func (s *prometheusSink) Accept(ctx context.Context, b *Batch) error {
+	if b.Check != nil {
+		name := prometheusMetricName(b.Check.Namespace, "CheckResult")
+		s.set(name, b.Dimensions, nil, float64(b.Check.Result))
+		name = prometheusMetricName(b.Check.Namespace, "CheckDuration_seconds")
+		s.set(name, b.Dimensions, nil, b.Check.Duration.Seconds())
+		return nil
+	}
+	for _, m := range b.Metrics {
+		s.set(prometheusMetricName(m.Namespace, m.Name), b.Dimensions, m.Dimensions, m.Value)
+	}
+	return nil
+}
+
+// set sets the value to the series of each dimension set. extra dimensions are added to all series as labels.
+func (s *prometheusSink) set(name string, dimensions [][]types.Dimension, extra []types.Dimension, value float64) {
+	if len(dimensions) == 0 {
+		s.registry.set(name, extra, value)
+	}
+	for _, ds := range dimensions {
+		s.registry.set(name, mergeDimensions(ds, extra), value)
+	}
+}
+
+func (s *prometheusSink) Flush(ctx context.Context) error {
+	return nil
+}
+
+func (s *prometheusSink) Close(ctx context.Context) error {
+	if s.w != nil {
+		s.registry.WriteTo(s.w)
+	}
+	if s.srv != nil {
+		return s.srv.Shutdown(ctx)
+	}
+	return nil
+}
+
+type prometheusSeries struct {
+	name   string
+	labels string
+	value  float64
+}
+
+// prometheusRegistry keeps the latest value of each series.
+type prometheusRegistry struct {
+	mu     sync.Mutex
+	series map[string]*prometheusSeries
+}
+
+func newPrometheusRegistry() *prometheusRegistry {
+	return &prometheusRegistry{
+		series: make(map[string]*prometheusSeries),
+	}
+}
+
+func (r *prometheusRegistry) set(name string, ds []types.Dimension, value float64) {
+	labels := prometheusLabels(ds)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key := name + labels
+	if s, ok := r.series[key]; ok {
+		s.value = value
+		return
+	}
+	r.series[key] = &prometheusSeries{name: name, labels: labels, value: value}
+}
+
+// WriteTo writes all series in the text exposition format.
+func (r *prometheusRegistry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	series := make([]prometheusSeries, 0, len(r.series))
+	for _, s := range r.series {
+		series = append(series, *s)
+	}
+	r.mu.Unlock()
+	sort.Slice(series, func(i, j int) bool {
+		if series[i].name != series[j].name {
+			return series[i].name < series[j].name
+		}
+		return series[i].labels < series[j].labels
+	})
+
+	var b strings.Builder
+	for i, s := range series {
+		if i == 0 || series[i-1].name != s.name {
+			fmt.Fprintf(&b, "# TYPE %s gauge\n", s.name)
+		}
+		fmt.Fprintf(&b, "%s%s %s\n", s.name, s.labels, strconv.FormatFloat(s.value, 'g', -1, 64))
+	}
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+func (r *prometheusRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	r.WriteTo(w)
+}
+
+func prometheusMetricName(namespace, name string) string {
+	if namespace == "" {
+		return sanitizePrometheusName(name, true)
+	}
+	return sanitizePrometheusName(namespace+"_"+name, true)
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func prometheusLabels(ds []types.Dimension) string {
+	if len(ds) == 0 {
+		return ""
+	}
+	labels := make([]string, 0, len(ds))
+	for _, d := range ds {
+		labels = append(labels, sanitizePrometheusName(aws.ToString(d.Name), false)+`="`+labelValueEscaper.Replace(aws.ToString(d.Value))+`"`)
+	}
+	sort.Strings(labels)
+	return "{" + strings.Join(labels, ",") + "}"
+}
+
+// sanitizePrometheusName replaces invalid characters in a metric or label name with '_'.
+// colon is allowed only in metric names.
+func sanitizePrometheusName(s string, metric bool) string {
+	b := []byte(s)
+	for i, c := range b {
+		switch {
+		case c == '_', 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z':
+		case c == ':' && metric:
+		case '0' <= c && c <= '9' && i > 0:
+		default:
+			b[i] = '_'
+		}
+	}
+	return string(b)
+}
@@ -0,0 +1,79 @@
+package sardine
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestPrometheusRegistry(t *testing.T) {
+	conf, err := parseConfig([]byte(`
+[prometheus]
+listen = "127.0.0.1:0"
+
+[plugin.metrics.memcached]
+command     = "true"
+destination = "prometheus"
+dimensions  = ["Host=127.0.0.1", "Host=127.0.0.1,Cluster=my\"cluster"]
+
+[plugin.check.memcached]
+command     = "true"
+namespace   = "memcached/check"
+destination = "prometheus"
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink := &prometheusSink{registry: newPrometheusRegistry()}
+	mp := conf.MetricPlugins["memcached"].(*CommandMetricPlugin)
+	mp.Destinations[0].Sink = sink
+	var metrics []*Metric
+	for _, line := range []string{
+		"memcached.cmd.cmd_get\t10\t1512057958",
+		"memcached.cmd.cmd_get\t20\t1512057959",
+		"memcached.2xx-hits.total\t1.5\t1512057958",
+	} {
+		m, err := mp.ParseMetricLine(line)
+		if err != nil {
+			t.Fatal(err)
+		}
+		metrics = append(metrics, m)
+	}
+	if err := mp.Enqueue(context.Background(), metrics); err != nil {
+		t.Fatal(err)
+	}
+
+	cp := conf.CheckPlugins["memcached"]
+	cp.Sink = sink
+	if err := cp.RunAtOnce(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	sink.registry.WriteTo(&buf)
+	// the duration of the check varies, so it is checked separately.
+	var got, durations []string
+	for _, line := range strings.SplitAfter(buf.String(), "\n") {
+		if strings.Contains(line, "CheckDuration") {
+			durations = append(durations, line)
+		} else {
+			got = append(got, line)
+		}
+	}
+	if len(durations) != 2 || durations[0] != "# TYPE memcached_check_CheckDuration_seconds gauge\n" || !strings.HasPrefix(durations[1], "memcached_check_CheckDuration_seconds ") {
+		t.Errorf("unexpected duration %q", durations)
+	}
+	expected := `# TYPE memcached_2xx_hits_total gauge
+memcached_2xx_hits_total{Cluster="my\"cluster",Host="127.0.0.1"} 1.5
+memcached_2xx_hits_total{Host="127.0.0.1"} 1.5
+# TYPE memcached_check_CheckResult gauge
+memcached_check_CheckResult 0
+# TYPE memcached_cmd_cmd_get gauge
+memcached_cmd_cmd_get{Cluster="my\"cluster",Host="127.0.0.1"} 20
+memcached_cmd_cmd_get{Host="127.0.0.1"} 20
+`
+	if got := strings.Join(got, ""); got != expected {
+		t.Errorf("unexpected exposition expected:\n%s\ngot:\n%s", expected, got)
+	}
+}
@@ -0,0 +1,118 @@
+package sardine
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"path"
+	"sync"
+	"time"
+)
+
+// RateMode is a mode to calculate per-second rates of cumulative metrics.
+type RateMode string
+
+const (
+	// RateCounter is a mode for monotonically increasing counters which may wrap around at 32 or 64 bits.
+	RateCounter RateMode = "counter"
+	// RateDerive is a mode for cumulative values. A decrease is treated as a reset.
+	RateDerive RateMode = "derive"
+)
+
+func parseRateMode(s string) (RateMode, error) {
+	switch m := RateMode(s); m {
+	case RateCounter, RateDerive:
+		return m, nil
+	default:
+		return "", fmt.Errorf("rate mode %s is not allowed. use counter or derive", s)
+	}
+}
+
+type rateSample struct {
+	value     float64
+	timestamp time.Time
+}
+
+// rateCalculator converts cumulative metrics into per-second rates.
+// It keeps the previous sample of each metric in memory.
+type rateCalculator struct {
+	id    string
+	rules map[string]RateMode
+	mu    sync.Mutex
+	prev  map[string]rateSample
+}
+
+func newRateCalculator(id string, rules map[string]string) (*rateCalculator, error) {
+	if len(rules) == 0 {
+		return nil, nil
+	}
+	rc := &rateCalculator{
+		id:    id,
+		rules: make(map[string]RateMode, len(rules)),
+		prev:  make(map[string]rateSample),
+	}
+	for pattern, mode := range rules {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern %s in rate: %w", pattern, err)
+		}
+		m, err := parseRateMode(mode)
+		if err != nil {
+			return nil, err
+		}
+		rc.rules[pattern] = m
+	}
+	return rc, nil
+}
+
+// apply replaces values of metrics matched with the rules by per-second rates.
+// The first sample of a metric, and a sample after a reset are skipped.
+func (rc *rateCalculator) apply(metrics []*Metric) []*Metric {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	res := make([]*Metric, 0, len(metrics))
+	for _, m := range metrics {
+		mode, ok := matchMetricPattern(rc.rules, m)
+		if !ok {
+			res = append(res, m)
+			continue
+		}
+		key := m.fullName()
+		prev, ok := rc.prev[key]
+		if ok && !m.Timestamp.After(prev.timestamp) {
+			// not a newer sample
+			continue
+		}
+		rc.prev[key] = rateSample{value: m.Value, timestamp: m.Timestamp}
+		if !ok {
+			continue
+		}
+		delta, ok := counterDelta(mode, prev.value, m.Value)
+		if !ok {
+			log.Printf("[%s] %s seems to be reset. skipped", rc.id, dottedMetricName(m.Namespace, m.Name))
+			continue
+		}
+		rate := *m
+		rate.Value = delta / m.Timestamp.Sub(prev.timestamp).Seconds()
+		res = append(res, &rate)
+	}
+	return res
+}
+
+// counterDelta returns the delta from prev to cur. It returns false when the counter is reset.
+// In counter mode, a decrease from the upper half of the 32 or 64 bits range is treated as a wraparound.
+func counterDelta(mode RateMode, prev, cur float64) (float64, bool) {
+	if cur >= prev {
+		return cur - prev, true
+	}
+	if mode != RateCounter {
+		return 0, false
+	}
+	switch {
+	case prev <= math.MaxUint32 && prev > math.MaxUint32/2:
+		return math.MaxUint32 - prev + cur + 1, true
+	case prev > math.MaxUint64/2:
+		return math.MaxUint64 - prev + cur + 1, true
+	default:
+		return 0, false
+	}
+}
@@ -0,0 +1,62 @@
+package sardine
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestRateCalculator(t *testing.T) {
+	rc, err := newRateCalculator("test", map[string]string{
+		"memcached.cmd.*":  "counter",
+		"memcached.curr_*": "derive",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := time.Unix(1512057958, 0)
+	metric := func(name string, v float64, sec int) *Metric {
+		return &Metric{Namespace: "memcached/cmd", Name: name, Value: v, Timestamp: base.Add(time.Duration(sec) * time.Second)}
+	}
+	tests := []struct {
+		metric   *Metric
+		expected []float64
+	}{
+		{metric("cmd_get", 100, 0), nil}, // first sample
+		{metric("cmd_get", 200, 10), []float64{10}},
+		{metric("cmd_get", 200, 10), nil}, // same timestamp
+		{metric("cmd_get", math.MaxUint32-9, 20), []float64{(math.MaxUint32 - 209) / 10.0}},
+		{metric("cmd_get", 10, 30), []float64{2}}, // 32 bit wraparound
+		{metric("cmd_get", 5, 40), nil},           // reset
+		{metric("cmd_get", 25, 50), []float64{2}},
+		{&Metric{Namespace: "redis/stats", Name: "items", Value: 10, Timestamp: base}, []float64{10}}, // not matched
+	}
+	for i, tt := range tests {
+		var got []float64
+		for _, m := range rc.apply([]*Metric{tt.metric}) {
+			got = append(got, m.Value)
+		}
+		if len(got) != len(tt.expected) {
+			t.Errorf("[%d] unexpected values expected:%v got:%v", i, tt.expected, got)
+			continue
+		}
+		for j := range got {
+			if math.Abs(got[j]-tt.expected[j]) > 1e-6 {
+				t.Errorf("[%d] unexpected values expected:%v got:%v", i, tt.expected, got)
+			}
+		}
+	}
+
+	if _, err := newRateCalculator("test", map[string]string{"*": "gauge"}); err == nil {
+		t.Error("invalid mode must be rejected")
+	}
+}
+
+func TestCounterDeltaDerive(t *testing.T) {
+	if _, ok := counterDelta(RateDerive, math.MaxUint32-1, 1); ok {
+		t.Error("decrease must be a reset in derive mode")
+	}
+	if d, ok := counterDelta(RateCounter, math.Pow(2, 64)-4096, 4096); !ok || math.Abs(d-8192) > 2 {
+		t.Errorf("unexpected 64 bit wraparound %g %v", d, ok)
+	}
+}
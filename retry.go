@@ -0,0 +1,140 @@
+package sardine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"time"
+
+	"github.com/aws/smithy-go"
+	mackerel "github.com/mackerelio/mackerel-client-go"
+)
+
+var (
+	DefaultRetryMaxAttempts     = 5
+	DefaultRetryInitialInterval = time.Second
+	DefaultRetryMaxInterval     = 30 * time.Second
+	DefaultRetryMaxElapsedTime  = time.Minute
+)
+
+// RetryConfig represents a config of retry for sending metrics.
+type RetryConfig struct {
+	MaxAttempts     int      `toml:"max_attempts"`
+	InitialInterval duration `toml:"initial_interval"`
+	MaxInterval     duration `toml:"max_interval"`
+	MaxElapsedTime  duration `toml:"max_elapsed_time"`
+}
+
+func (rc *RetryConfig) setDefaults() error {
+	if rc.MaxAttempts < 0 {
+		return fmt.Errorf("[retry] max_attempts must be positive")
+	}
+	if rc.MaxAttempts == 0 {
+		rc.MaxAttempts = DefaultRetryMaxAttempts
+	}
+	if rc.InitialInterval.Duration == 0 {
+		rc.InitialInterval.Duration = DefaultRetryInitialInterval
+	}
+	if rc.MaxInterval.Duration == 0 {
+		rc.MaxInterval.Duration = DefaultRetryMaxInterval
+	}
+	if rc.MaxElapsedTime.Duration == 0 {
+		rc.MaxElapsedTime.Duration = DefaultRetryMaxElapsedTime
+	}
+	return nil
+}
+
+// errRetryTimeout is returned by Do when max_elapsed_time is exceeded. It is retryable later (e.g. by the spool).
+var errRetryTimeout = errors.New("max_elapsed_time exceeded")
+
+// Do calls fn until it succeeds.
+// It gives up when fn returns a non-retryable error, max_attempts is reached,
+// or max_elapsed_time is exceeded. fn is called with a context which is cancelled at max_elapsed_time,
+// so the whole retry including the calls is bounded.
+func (rc *RetryConfig) Do(ctx context.Context, name string, fn func(ctx context.Context) error) error {
+	start := time.Now()
+	tctx, cancel := context.WithTimeout(ctx, rc.MaxElapsedTime.Duration)
+	defer cancel()
+	interval := rc.InitialInterval.Duration
+	for attempt := 1; ; attempt++ {
+		err := fn(tctx)
+		if err == nil {
+			return nil
+		}
+		if tctx.Err() != nil && ctx.Err() == nil {
+			return fmt.Errorf("gave up after %d attempts in %s (%s): %w", attempt, time.Since(start).Round(time.Millisecond), err, errRetryTimeout)
+		}
+		if !isRetryableError(err) {
+			return err
+		}
+		if attempt >= rc.MaxAttempts {
+			return fmt.Errorf("gave up after %d attempts: %w", attempt, err)
+		}
+		// equal jitter
+		wait := interval/2 + time.Duration(rand.Int63n(int64(interval/2)+1))
+		if time.Since(start)+wait > rc.MaxElapsedTime.Duration {
+			return fmt.Errorf("gave up after %d attempts in %s: %w", attempt, time.Since(start).Round(time.Millisecond), err)
+		}
+		log.Printf("%s failed: %s. retrying in %s (%d/%d)", name, err, wait.Round(time.Millisecond), attempt, rc.MaxAttempts)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(wait):
+		}
+		interval *= 2
+		if interval > rc.MaxInterval.Duration {
+			interval = rc.MaxInterval.Duration
+		}
+	}
+}
+
+// permanentError represents an error which must not be retried.
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
+
+// isRetryableError reports whether err is a temporary error, such as throttling, server errors or network errors.
+func isRetryableError(err error) bool {
+	if errors.Is(err, errRetryTimeout) {
+		return true
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var pe *permanentError
+	if errors.As(err, &pe) {
+		return false
+	}
+	var ae smithy.APIError
+	if errors.As(err, &ae) {
+		switch ae.ErrorCode() {
+		case "Throttling", "ThrottlingException", "RequestLimitExceeded", "TooManyRequestsException":
+			return true
+		}
+	}
+	var he interface{ HTTPStatusCode() int }
+	if errors.As(err, &he) {
+		return isRetryableStatus(he.HTTPStatusCode())
+	}
+	var me *mackerel.APIError
+	if errors.As(err, &me) {
+		return isRetryableStatus(me.StatusCode)
+	}
+	// network errors
+	return true
+}
+
+func isRetryableStatus(code int) bool {
+	return code == http.StatusTooManyRequests || code >= http.StatusInternalServerError
+}
@@ -0,0 +1,93 @@
+package sardine
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	mackerel "github.com/mackerelio/mackerel-client-go"
+)
+
+func TestRetryConfigDo(t *testing.T) {
+	rc := &RetryConfig{MaxAttempts: 3}
+	rc.InitialInterval.Duration = time.Millisecond
+	if err := rc.setDefaults(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		err      error
+		attempts int
+	}{
+		{"ok", nil, 1},
+		{"network error", errors.New("connection refused"), 3},
+		{"server error", &mackerel.APIError{StatusCode: http.StatusServiceUnavailable}, 3},
+		{"too many requests", &mackerel.APIError{StatusCode: http.StatusTooManyRequests}, 3},
+		{"bad request", &mackerel.APIError{StatusCode: http.StatusBadRequest}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attempts := 0
+			err := rc.Do(context.Background(), tt.name, func(ctx context.Context) error {
+				attempts++
+				return tt.err
+			})
+			if !errors.Is(err, tt.err) {
+				t.Errorf("unexpected error expected:%v got:%v", tt.err, err)
+			}
+			if attempts != tt.attempts {
+				t.Errorf("unexpected attempts expected:%d got:%d", tt.attempts, attempts)
+			}
+		})
+	}
+}
+
+func TestRetryConfigDoMaxElapsedTime(t *testing.T) {
+	rc := &RetryConfig{MaxAttempts: 100}
+	rc.InitialInterval.Duration = 10 * time.Millisecond
+	rc.MaxElapsedTime.Duration = 50 * time.Millisecond
+	if err := rc.setDefaults(); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	attempts := 0
+	err := rc.Do(context.Background(), "test", func(ctx context.Context) error {
+		attempts++
+		return errors.New("connection refused")
+	})
+	if err == nil {
+		t.Error("must be failed")
+	}
+	if elapsed := time.Since(start); elapsed > 200*time.Millisecond {
+		t.Errorf("retry must give up in max_elapsed_time: %s", elapsed)
+	}
+	if attempts < 2 {
+		t.Errorf("unexpected attempts %d", attempts)
+	}
+}
+
+func TestRetryConfigDoMaxElapsedTimeSlowCall(t *testing.T) {
+	rc := &RetryConfig{MaxAttempts: 3}
+	rc.MaxElapsedTime.Duration = 50 * time.Millisecond
+	if err := rc.setDefaults(); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	err := rc.Do(context.Background(), "test", func(ctx context.Context) error {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Second):
+			return nil
+		}
+	})
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("calls must be bounded by max_elapsed_time: %s", elapsed)
+	}
+	if !isRetryableError(err) {
+		t.Errorf("exceeding max_elapsed_time must be retryable later: %v", err)
+	}
+}
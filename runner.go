@@ -0,0 +1,156 @@
+package sardine
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+)
+
+type pluginKey struct {
+	section string
+	id      string
+}
+
+func (k pluginKey) String() string {
+	return fmt.Sprintf("plugin.%s.%s", k.section, k.id)
+}
+
+type runningPlugin struct {
+	config *PluginConfig
+	cancel context.CancelFunc
+	wg     *sync.WaitGroup
+}
+
+// pluginRunner manages goroutines of plugins, and replaces them when a config is reloaded.
+type pluginRunner struct {
+	sinks   *sinkSet
+	running map[pluginKey]*runningPlugin
+}
+
+func newPluginRunner(sinks *sinkSet) *pluginRunner {
+	return &pluginRunner{
+		sinks:   sinks,
+		running: make(map[pluginKey]*runningPlugin),
+	}
+}
+
+// apply stops plugins removed or changed in conf, and starts plugins added or changed in conf.
+// Plugins which have the same config keep running. conf must be attached to the sinks before.
+func (r *pluginRunner) apply(ctx context.Context, conf *Config) {
+	var stopping []*runningPlugin
+	for key, rp := range r.running {
+		if pc := conf.Plugin[key.section][key.id]; pc != nil && reflect.DeepEqual(pc, rp.config) {
+			continue
+		}
+		log.Printf("[%s] stopping", key)
+		rp.cancel()
+		stopping = append(stopping, rp)
+		delete(r.running, key)
+	}
+	for _, rp := range stopping {
+		rp.wg.Wait()
+	}
+
+	for _, id := range sortedKeys(conf.MetricPlugins) {
+		key := pluginKey{section: "metrics", id: id}
+		if _, ok := r.running[key]; ok {
+			continue
+		}
+		mp := conf.MetricPlugins[id]
+		r.start(ctx, key, conf.Plugin[key.section][id], func(ctx context.Context, wg *sync.WaitGroup) {
+			runMetricPlugin(ctx, wg, mp)
+		})
+	}
+	for _, id := range sortedKeys(conf.CheckPlugins) {
+		key := pluginKey{section: "check", id: id}
+		if _, ok := r.running[key]; ok {
+			continue
+		}
+		cp := conf.CheckPlugins[id]
+		r.start(ctx, key, conf.Plugin[key.section][id], func(ctx context.Context, wg *sync.WaitGroup) {
+			cp.Run(ctx, wg)
+		})
+	}
+}
+
+func (r *pluginRunner) start(ctx context.Context, key pluginKey, pc *PluginConfig, run func(context.Context, *sync.WaitGroup)) {
+	pctx, cancel := context.WithCancel(ctx)
+	rp := &runningPlugin{
+		config: pc,
+		cancel: cancel,
+		wg:     new(sync.WaitGroup),
+	}
+	rp.wg.Add(1)
+	go run(pctx, rp.wg)
+	r.running[key] = rp
+	time.Sleep(time.Second)
+}
+
+// stop stops all running plugins and waits for them.
+func (r *pluginRunner) stop() {
+	for _, rp := range r.running {
+		rp.cancel()
+	}
+	for key, rp := range r.running {
+		rp.wg.Wait()
+		delete(r.running, key)
+	}
+}
+
+// reload loads the config and applies it to the runner, and returns the applied config.
+// When the config is broken, the running plugins are kept as is and prev is returned.
+// Unless force is true, the config which is equal to prev is not applied.
+func (r *pluginRunner) reload(ctx context.Context, configPath string, prev []byte, force bool) []byte {
+	b, err := loadURL(ctx, configPath)
+	if err != nil {
+		log.Printf("failed to reload config: %s. keep running with the current config", err)
+		return prev
+	}
+	if !force && bytes.Equal(b, prev) {
+		return prev
+	}
+	conf, err := parseConfig(b)
+	if err != nil {
+		log.Printf("failed to reload config: %s. keep running with the current config", err)
+		return prev
+	}
+	if len(conf.MetricPlugins) == 0 && len(conf.CheckPlugins) == 0 {
+		log.Println("failed to reload config: no plugins are defined. keep running with the current config")
+		return prev
+	}
+	// sinks are resolved before stopping any plugins, so a destination which is not available keeps the current plugins.
+	if err := r.sinks.attach(ctx, conf); err != nil {
+		log.Printf("failed to reload config: %s. keep running with the current config", err)
+		return prev
+	}
+	log.Println("config reloaded")
+	r.apply(ctx, conf)
+	return b
+}
+
+func isRemoteURL(p string) bool {
+	u, err := url.Parse(p)
+	if err != nil {
+		return false
+	}
+	switch u.Scheme {
+	case "http", "https", "s3":
+		return true
+	}
+	return false
+}
+
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,148 @@
+package sardine
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type nopSink struct{}
+
+func (s *nopSink) Accept(ctx context.Context, b *Batch) error { return nil }
+func (s *nopSink) Flush(ctx context.Context) error            { return nil }
+func (s *nopSink) Close(ctx context.Context) error            { return nil }
+
+func TestPluginRunnerApply(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sinks := newSinkSet(&Config{})
+	sinks.sinks["cloudwatch"] = &nopSink{}
+	r := newPluginRunner(sinks)
+	defer r.stop()
+
+	conf, err := parseConfig([]byte(`
+[plugin.metrics.keep]
+command = "true"
+[plugin.metrics.change]
+command = "true"
+[plugin.metrics.remove]
+command = "true"
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sinks.attach(ctx, conf); err != nil {
+		t.Fatal(err)
+	}
+	r.apply(ctx, conf)
+	if len(r.running) != 3 {
+		t.Fatalf("unexpected running plugins expected:3 got:%d", len(r.running))
+	}
+	keep := r.running[pluginKey{"metrics", "keep"}]
+	change := r.running[pluginKey{"metrics", "change"}]
+
+	conf, err = parseConfig([]byte(`
+[plugin.metrics.keep]
+command = "true"
+[plugin.metrics.change]
+command = "true"
+interval = "10s"
+[plugin.check.add]
+namespace = "test/check"
+command = "true"
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sinks.attach(ctx, conf); err != nil {
+		t.Fatal(err)
+	}
+	r.apply(ctx, conf)
+	if len(r.running) != 3 {
+		t.Fatalf("unexpected running plugins expected:3 got:%d", len(r.running))
+	}
+	if r.running[pluginKey{"metrics", "keep"}] != keep {
+		t.Error("unchanged plugin must keep running")
+	}
+	if r.running[pluginKey{"metrics", "change"}] == change {
+		t.Error("changed plugin must be restarted")
+	}
+	if _, ok := r.running[pluginKey{"metrics", "remove"}]; ok {
+		t.Error("removed plugin must be stopped")
+	}
+	if _, ok := r.running[pluginKey{"check", "add"}]; !ok {
+		t.Error("added plugin must be started")
+	}
+}
+
+func TestPluginRunnerReload(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	RegisterSink("test_unavailable", func(ctx context.Context, c *Config) (Sink, error) {
+		return nil, errors.New("unavailable")
+	})
+	sinks := newSinkSet(&Config{})
+	sinks.sinks["cloudwatch"] = &nopSink{}
+	r := newPluginRunner(sinks)
+	defer r.stop()
+
+	current := []byte("[plugin.metrics.keep]\ncommand = \"true\"\n")
+	var status int
+	var body string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(status)
+		io.WriteString(w, body)
+	}))
+	defer srv.Close()
+
+	status, body = http.StatusOK, string(current)
+	if b := r.reload(ctx, srv.URL, nil, false); !bytes.Equal(b, current) {
+		t.Fatalf("unexpected config %s", b)
+	}
+	keep := r.running[pluginKey{"metrics", "keep"}]
+	for _, tt := range []struct {
+		status int
+		body   string
+		force  bool
+	}{
+		{http.StatusInternalServerError, "", false},
+		{http.StatusNotFound, "", true},
+		{http.StatusOK, "", true},
+		{http.StatusOK, "[plugin.metrics.broken]\n", true},
+		{http.StatusOK, "[plugin.metrics.keep]\ncommand = \"true\"\ndestinations = [\"cloudwatch\", \"test_unavailable\"]\n", true},
+	} {
+		status, body = tt.status, tt.body
+		if b := r.reload(ctx, srv.URL, current, tt.force); !bytes.Equal(b, current) {
+			t.Errorf("current config must be kept on status %d with %q: %s", tt.status, tt.body, b)
+		}
+		if r.running[pluginKey{"metrics", "keep"}] != keep {
+			t.Errorf("plugin must keep running on status %d with %q", tt.status, tt.body)
+		}
+	}
+}
+
+func TestSinkSetAttachReloadedConfig(t *testing.T) {
+	sinks := newSinkSet(&Config{})
+	conf, err := parseConfig([]byte(`
+[prometheus]
+listen = "127.0.0.1:0"
+[plugin.metrics.prom]
+command     = "true"
+destination = "prometheus"
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sinks.attach(context.Background(), conf); err != nil {
+		t.Fatal(err)
+	}
+	defer sinks.close(context.Background())
+	s, ok := sinks.sinks["prometheus"].(*prometheusSink)
+	if !ok || s.srv == nil {
+		t.Error("prometheus sink must be created with the reloaded config")
+	}
+}
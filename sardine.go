@@ -2,16 +2,12 @@ package sardine
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"log"
 	"os"
-	"sync"
+	"os/signal"
+	"syscall"
 	"time"
-
-	awsConfig "github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
-	mackerel "github.com/mackerelio/mackerel-client-go"
 )
 
 var (
@@ -19,141 +15,122 @@ var (
 	DefaultInterval       = time.Minute
 	DefaultCommandTimeout = time.Minute
 
-	maxMetricDatum = 20
+	// ConfigPollInterval is an interval to poll the config from http(s) or s3 URL.
+	// The config is reloaded when its content is changed. 0 disables polling.
+	ConfigPollInterval time.Duration
+
+	// ShutdownTimeout is a timeout to flush queued metrics on shutdown.
+	ShutdownTimeout = 10 * time.Second
+
+	// DryRun prints metrics to stdout instead of sending them by the built-in sinks.
+	DryRun = false
+	// DryRunFormat is an output format of DryRun. "text" or "json".
+	DryRunFormat = "text"
 )
 
 func Run(ctx context.Context, configPath string) error {
-	cch := make(chan *cloudwatch.PutMetricDataInput, 1000)
-	mch := make(chan ServiceMetric, 1000)
-	conf, err := LoadConfig(ctx, configPath)
+	configBytes, err := loadURL(ctx, configPath)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+	conf, err := parseConfig(configBytes)
 	if err != nil {
 		return err
 	}
 
-	wg := new(sync.WaitGroup)
-	wg.Add(2)
-	go putToCloudWatch(ctx, wg, cch)
-	go putToMackerel(ctx, wg, mch)
-
-	for _, _mp := range conf.MetricPlugins {
-		switch mp := _mp.(type) {
-		case *CloudWatchMetricPlugin:
-			mp.Ch = cch
-			wg.Add(1)
-			go runMetricPlugin(ctx, wg, mp)
-		case *MackerelMetricPlugin:
-			mp.Ch = mch
-			wg.Add(1)
-			go runMetricPlugin(ctx, wg, mp)
-		}
-		time.Sleep(time.Second)
+	// SIGHUP is handled before starting plugins, not to be killed by the default action while starting them.
+	// SIGHUPs received while starting are handled after that.
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	// sinks are created at the first use with the latest config, and not replaced by reloading.
+	sinks := newSinkSet(conf)
+	if err := sinks.attach(ctx, conf); err != nil {
+		log.Println(err)
 	}
-	for _, cp := range conf.CheckPlugins {
-		wg.Add(1)
-		go cp.Run(ctx, wg, cch)
-		time.Sleep(time.Second)
+	runner := newPluginRunner(sinks)
+	runner.apply(ctx, conf)
+
+	var poll <-chan time.Time
+	if ConfigPollInterval > 0 && isRemoteURL(configPath) {
+		log.Printf("polling config %s every %s", configPath, ConfigPollInterval)
+		ticker := time.NewTicker(ConfigPollInterval)
+		defer ticker.Stop()
+		poll = ticker.C
 	}
 
-	<-ctx.Done()
-	log.Println("shutting down. waiting for complete...")
-	close(cch)
-	close(mch)
-	wg.Wait()
+LOOP:
+	for {
+		select {
+		case <-ctx.Done():
+			break LOOP
+		case <-hup:
+			log.Println("SIGHUP received. reloading config")
+			configBytes = runner.reload(ctx, configPath, configBytes, true)
+		case <-poll:
+			configBytes = runner.reload(ctx, configPath, configBytes, false)
+		}
+	}
+
+	log.Println("shutting down. waiting for plugins to stop...")
+	runner.stop()
+
+	// sinks are not closed by ctx, to flush queued metrics on shutdown.
+	log.Printf("flushing queued batches in %s", ShutdownTimeout)
+	sctx, cancel := context.WithTimeout(context.Background(), ShutdownTimeout)
+	defer cancel()
+	sinks.flush(sctx)
+	sinks.close(sctx)
 	log.Println("shutdown complete")
 	return nil
 }
 
 func RunAtOnce(ctx context.Context, configPath string) error {
-	cch := make(chan *cloudwatch.PutMetricDataInput, 10000)
-	mch := make(chan ServiceMetric, 10000)
 	conf, err := LoadConfig(ctx, configPath)
 	if err != nil {
 		return err
 	}
+	if conf.usePrometheus() && !DryRun {
+		log.Println("metrics for destination prometheus are not exposed in at-once mode")
+	}
+	conf.Prometheus.Listen = ""
 
-	wg := new(sync.WaitGroup)
-	for _, _mp := range conf.MetricPlugins {
-		switch mp := _mp.(type) {
-		case *CloudWatchMetricPlugin:
-			mp.Ch = cch
-			log.Printf("[%s] run", mp.ID())
-			runMetricPluginAtOnce(ctx, mp)
-		case *MackerelMetricPlugin:
-			mp.Ch = mch
-			log.Printf("[%s] run", mp.ID())
-			runMetricPluginAtOnce(ctx, mp)
+	sinks := newSinkSet(conf)
+	if err := sinks.attach(ctx, conf); err != nil {
+		log.Println(err)
+	}
+	for _, id := range sortedKeys(conf.MetricPlugins) {
+		mp := conf.MetricPlugins[id]
+		log.Printf("[%s] run", mp.ID())
+		loadPluginMeta(mp)
+		if err := runMetricPluginAtOnce(ctx, mp); err != nil {
+			log.Println(err)
 		}
 	}
-	for _, cp := range conf.CheckPlugins {
+	for _, id := range sortedKeys(conf.CheckPlugins) {
+		cp := conf.CheckPlugins[id]
 		log.Printf("[%s] run", cp.ID)
-		cp.RunAtOnce(ctx, cch)
+		if err := cp.RunAtOnce(ctx); err != nil {
+			log.Println(err)
+		}
 	}
-	close(cch)
-	close(mch)
-	wg.Add(2)
-	putToCloudWatch(ctx, wg, cch)
-	putToMackerel(ctx, wg, mch)
 
 	log.Println("shutting down. waiting for complete...")
-	wg.Wait()
+	sinks.flush(ctx)
+	sinks.close(ctx)
 	log.Println("shutdown complete")
 	return nil
 }
 
-func putToCloudWatch(ctx context.Context, wg *sync.WaitGroup, ch chan *cloudwatch.PutMetricDataInput) {
-	defer wg.Done()
-	region := os.Getenv("AWS_REGION")
-	awscfg, err := awsConfig.LoadDefaultConfig(ctx, awsConfig.WithRegion(region))
-	if err != nil {
-		panic(fmt.Errorf("failed to load aws config: %w", err))
+// spoolOrDrop stores v into sp if the spool is enabled. It returns false when v is dropped.
+func spoolOrDrop(sp *spool, v interface{}) bool {
+	if sp == nil {
+		return false
 	}
-	svc := cloudwatch.NewFromConfig(awscfg)
-
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		case in, ok := <-ch:
-			if !ok {
-				log.Println("putToCloudWatch: channel closed")
-				return
-			}
-			if Debug {
-				b, _ := json.Marshal(in)
-				log.Printf("putToCloudWatch: %s", b)
-			}
-			_, err := svc.PutMetricData(ctx, in)
-			if err != nil {
-				log.Println("PutMetricData to CloudWatch failed:", err)
-			}
-		}
-	}
-}
-
-func putToMackerel(ctx context.Context, wg *sync.WaitGroup, ch chan ServiceMetric) {
-	defer wg.Done()
-	c := mackerel.NewClient(os.Getenv("MACKEREL_APIKEY"))
-
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		case in, ok := <-ch:
-			if !ok {
-				log.Println("putToMackerel: channel closed")
-				return
-			}
-			if len(in.MetricValues) == 0 {
-				continue
-			}
-			if Debug {
-				b, _ := json.Marshal(in)
-				log.Printf("putToMackerel: %s", b)
-			}
-			err := c.PostServiceMetricValues(in.Service, in.MetricValues)
-			if err != nil {
-				log.Println("PostServiceMetricValues to Mackerel failed:", err)
-			}
-		}
+	if err := sp.Put(v); err != nil {
+		log.Printf("[spool] %s: %s", sp.name, err)
+		return false
 	}
+	return true
 }
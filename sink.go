@@ -0,0 +1,304 @@
+package sardine
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
+)
+
+// Sink is a destination of metrics.
+type Sink interface {
+	// Accept accepts a batch of metrics. It may block while the sink is busy, until ctx is cancelled.
+	Accept(ctx context.Context, b *Batch) error
+	// Flush sends all accepted batches to the destination, and waits for completion.
+	// It is called after all plugins are executed in at-once mode, and after all plugins are stopped on shutdown.
+	Flush(ctx context.Context) error
+	// Close flushes accepted batches and releases the resources.
+	// Batches which are not flushed until ctx is cancelled are dropped.
+	Close(ctx context.Context) error
+}
+
+// Batch is a set of metrics produced by one execution of a plugin.
+// Sinks must not modify the batch, because the metrics are shared by all destinations of the plugin.
+type Batch struct {
+	PluginID string
+	Metrics  []*Metric
+	// Dimensions are dimension sets which are attached to each metric.
+	Dimensions [][]types.Dimension
+	// Service is a service name of Mackerel.
+	Service string
+	// StorageResolution is a storage resolution of CloudWatch metrics. 0 means the default.
+	StorageResolution int32
+	// AggregationWindow is a window to aggregate metrics into CloudWatch statistic sets. 0 disables aggregation.
+	AggregationWindow time.Duration
+	// Check is set when the batch is produced by a check plugin.
+	Check *CheckReport
+}
+
+// CheckReport represents a result of a check plugin.
+type CheckReport struct {
+	// Name is a name of the check. e.g. memcached for [plugin.check.memcached]
+	Name      string
+	Namespace string
+	Result    CheckResult
+	// Message is the first line of the output of the check command.
+	Message   string
+	Timestamp time.Time
+	// HostID is a host ID of Mackerel to report the result.
+	HostID string
+	// SoftState is true while the latest result is a non-OK soft state. Result is the hard state.
+	SoftState bool
+	// Duration is a wall time of the execution of the check command.
+	Duration time.Duration
+}
+
+// SinkFactory creates a Sink for the config.
+// ctx is used only while creating a sink. The sink must work until Close is called.
+type SinkFactory func(ctx context.Context, c *Config) (Sink, error)
+
+var (
+	sinkFactoriesMu sync.RWMutex
+	sinkFactories   = make(map[string]SinkFactory)
+)
+
+func init() {
+	RegisterSink("cloudwatch", newCloudWatchSink)
+	RegisterSink("mackerel", newMackerelSink)
+	RegisterSink("prometheus", newPrometheusSink)
+	RegisterSink("otlp", newOTLPSink)
+}
+
+// RegisterSink registers a sink factory as a destination name. The name is case-insensitive.
+// Plugins which have the destination send metrics to the sink created by the factory.
+// The factory is called once at the first use of the destination.
+func RegisterSink(name string, f SinkFactory) {
+	sinkFactoriesMu.Lock()
+	defer sinkFactoriesMu.Unlock()
+	sinkFactories[strings.ToLower(name)] = f
+}
+
+func lookupSinkFactory(name string) (SinkFactory, bool) {
+	sinkFactoriesMu.RLock()
+	defer sinkFactoriesMu.RUnlock()
+	f, ok := sinkFactories[name]
+	return f, ok
+}
+
+// sinkSet holds sinks created for destinations.
+type sinkSet struct {
+	conf  *Config
+	mu    sync.Mutex
+	sinks map[string]Sink
+}
+
+func newSinkSet(conf *Config) *sinkSet {
+	return &sinkSet{
+		conf:  conf,
+		sinks: make(map[string]Sink),
+	}
+}
+
+// get returns the sink for the destination. It creates the sink at the first time.
+func (s *sinkSet) get(ctx context.Context, name string) (Sink, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if sink, ok := s.sinks[name]; ok {
+		return sink, nil
+	}
+	f, ok := lookupSinkFactory(name)
+	if !ok {
+		return nil, fmt.Errorf("destination %s is not registered", name)
+	}
+	sink, err := f(ctx, s.conf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create sink for destination %s: %w", name, err)
+	}
+	s.sinks[name] = sink
+	return sink, nil
+}
+
+// attach sets sinks of the destinations to the plugins in conf.
+// Sinks which are not created yet are created with conf. Existing sinks are kept as is.
+// Plugins whose sink is not available are removed from conf, and the errors are returned as ConfigErrors.
+func (s *sinkSet) attach(ctx context.Context, conf *Config) error {
+	s.mu.Lock()
+	prev := s.conf
+	s.conf = conf
+	s.mu.Unlock()
+	var errs ConfigErrors
+	for _, id := range sortedKeys(conf.MetricPlugins) {
+		mp, ok := conf.MetricPlugins[id].(*CommandMetricPlugin)
+		if !ok {
+			continue
+		}
+		for _, d := range mp.Destinations {
+			sink, err := s.get(ctx, d.Name)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("[%s] %w", mp.ID(), err))
+				delete(conf.MetricPlugins, id)
+				break
+			}
+			d.Sink = sink
+		}
+	}
+	for _, id := range sortedKeys(conf.CheckPlugins) {
+		cp := conf.CheckPlugins[id]
+		sink, err := s.get(ctx, cp.Destination)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("[%s] %w", cp.ID, err))
+			delete(conf.CheckPlugins, id)
+			continue
+		}
+		cp.Sink = sink
+	}
+	if len(errs) > 0 {
+		// sinks created later must not use the config which is not available.
+		s.mu.Lock()
+		s.conf = prev
+		s.mu.Unlock()
+		return errs
+	}
+	return nil
+}
+
+// flush flushes all sinks concurrently, and waits for completion.
+func (s *sinkSet) flush(ctx context.Context) {
+	s.each(func(name string, sink Sink) {
+		if err := sink.Flush(ctx); err != nil {
+			log.Printf("failed to flush sink %s: %s", name, err)
+		}
+	})
+}
+
+// close closes all sinks concurrently.
+func (s *sinkSet) close(ctx context.Context) {
+	s.each(func(name string, sink Sink) {
+		if err := sink.Close(ctx); err != nil {
+			log.Printf("failed to close sink %s: %s", name, err)
+		}
+	})
+}
+
+// each calls fn for each sink concurrently, and waits for all calls.
+func (s *sinkSet) each(fn func(string, Sink)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var wg sync.WaitGroup
+	for name, sink := range s.sinks {
+		wg.Add(1)
+		go func(name string, sink Sink) {
+			defer wg.Done()
+			fn(name, sink)
+		}(name, sink)
+	}
+	wg.Wait()
+}
+
+// queue is a queue of batches for a sink. The batches are sent one by one by a goroutine.
+type queue struct {
+	name    string
+	ch      chan interface{}
+	pending sync.WaitGroup
+	done    chan struct{}
+	dropped int64
+	// sending is the number of batches in the queue or being sent.
+	sending int64
+	// sctx is a context for sending. It is cancelled when close is timed out.
+	sctx   context.Context
+	cancel context.CancelFunc
+}
+
+func newQueue(name string, size int) *queue {
+	sctx, cancel := context.WithCancel(context.Background())
+	return &queue{
+		name:   name,
+		ch:     make(chan interface{}, size),
+		done:   make(chan struct{}),
+		sctx:   sctx,
+		cancel: cancel,
+	}
+}
+
+// put puts v into the queue. It blocks while the queue is full.
+func (q *queue) put(ctx context.Context, v interface{}) error {
+	q.pending.Add(1)
+	select {
+	case q.ch <- v:
+		atomic.AddInt64(&q.sending, 1)
+		return nil
+	case <-ctx.Done():
+		q.pending.Done()
+		return ctx.Err()
+	}
+}
+
+// run calls send for each batch until the queue is closed. send returns false when the batch is dropped.
+// When interval is not zero, tick is called at start and every interval.
+func (q *queue) run(send func(context.Context, interface{}) bool, interval time.Duration, tick func(context.Context)) {
+	defer close(q.done)
+	var tc <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tc = ticker.C
+		tick(q.sctx)
+	}
+	for {
+		select {
+		case <-tc:
+			tick(q.sctx)
+		case v, ok := <-q.ch:
+			if !ok {
+				return
+			}
+			if !send(q.sctx, v) {
+				atomic.AddInt64(&q.dropped, 1)
+			}
+			atomic.AddInt64(&q.sending, -1)
+			q.pending.Done()
+		}
+	}
+}
+
+// flush waits until all batches in the queue are sent.
+func (q *queue) flush(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		q.pending.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// close closes the queue and waits until all batches are sent.
+// When ctx is cancelled, sending is cancelled and the remaining batches are spooled or dropped by send.
+func (q *queue) close(ctx context.Context) error {
+	queued := atomic.LoadInt64(&q.sending)
+	before := atomic.LoadInt64(&q.dropped)
+	close(q.ch)
+	log.Printf("[%s] flushing %d queued batches", q.name, queued)
+	var err error
+	select {
+	case <-q.done:
+	case <-ctx.Done():
+		log.Printf("[%s] flushing timed out. remaining batches are dropped", q.name)
+		err = ctx.Err()
+		q.cancel()
+		<-q.done
+	}
+	q.cancel()
+	dropped := atomic.LoadInt64(&q.dropped) - before
+	log.Printf("[%s] %d batches flushed, %d batches dropped", q.name, queued-dropped, dropped)
+	return err
+}
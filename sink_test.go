@@ -0,0 +1,81 @@
+package sardine_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/fujiwara/sardine"
+)
+
+type testSink struct {
+	mu      sync.Mutex
+	batches []*sardine.Batch
+	flushed bool
+	closed  bool
+}
+
+func (s *testSink) Accept(ctx context.Context, b *sardine.Batch) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.batches = append(s.batches, b)
+	return nil
+}
+
+func (s *testSink) Flush(ctx context.Context) error {
+	s.flushed = !s.closed
+	return nil
+}
+
+func (s *testSink) Close(ctx context.Context) error {
+	s.closed = true
+	return nil
+}
+
+func TestRegisterSink(t *testing.T) {
+	sink := &testSink{}
+	sardine.RegisterSink("test", func(ctx context.Context, c *sardine.Config) (sardine.Sink, error) {
+		return sink, nil
+	})
+	path := filepath.Join(t.TempDir(), "config.toml")
+	err := os.WriteFile(path, []byte(`
+[plugin.metrics.test]
+command     = "printf 'test.cmd.get\t10\t1512057958\n'"
+destination = "test"
+dimensions  = ["Host=127.0.0.1"]
+
+[plugin.check.test]
+command     = "true"
+namespace   = "test/check"
+destination = "test"
+`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sardine.RunAtOnce(context.Background(), path); err != nil {
+		t.Fatal(err)
+	}
+
+	if !sink.flushed {
+		t.Error("sink must be flushed before closed")
+	}
+	if !sink.closed {
+		t.Error("sink must be closed")
+	}
+	if len(sink.batches) != 2 {
+		t.Fatalf("unexpected batches len expected:2 got:%d", len(sink.batches))
+	}
+	mb := sink.batches[0]
+	if mb.PluginID != "plugin.metrics.test" || len(mb.Dimensions) != 1 {
+		t.Errorf("unexpected metric batch %#v", mb)
+	}
+	if m := mb.Metrics[0]; m.Namespace != "test/cmd" || m.Name != "get" || m.Value != 10 {
+		t.Errorf("unexpected metric %#v", m)
+	}
+	cb := sink.batches[1]
+	if cb.Check == nil || cb.Check.Result != sardine.CheckOK || cb.Check.Namespace != "test/check" {
+		t.Errorf("unexpected check batch %#v", cb)
+	}
+}
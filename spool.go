@@ -0,0 +1,223 @@
+package sardine
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	DefaultSpoolMaxBytes       int64 = 100 * 1024 * 1024
+	DefaultSpoolMaxAge               = 14 * 24 * time.Hour
+	DefaultSpoolReplayInterval       = time.Minute
+	DefaultSpoolMaxAttempts          = 5
+)
+
+// SpoolConfig represents a config of the spool for metrics which could not be delivered.
+type SpoolConfig struct {
+	Dir            string
+	MaxBytes       int64    `toml:"max_bytes"`
+	MaxAge         duration `toml:"max_age"`
+	ReplayInterval duration `toml:"replay_interval"`
+	MaxAttempts    int      `toml:"max_attempts"`
+}
+
+func (sc *SpoolConfig) setDefaults() error {
+	if sc.Dir == "" {
+		return nil
+	}
+	if sc.MaxBytes < 0 {
+		return fmt.Errorf("[spool] max_bytes must be positive")
+	}
+	if sc.MaxBytes == 0 {
+		sc.MaxBytes = DefaultSpoolMaxBytes
+	}
+	if sc.MaxAge.Duration == 0 {
+		sc.MaxAge.Duration = DefaultSpoolMaxAge
+	}
+	if sc.ReplayInterval.Duration == 0 {
+		sc.ReplayInterval.Duration = DefaultSpoolReplayInterval
+	}
+	if sc.MaxAttempts < 0 {
+		return fmt.Errorf("[spool] max_attempts must be positive")
+	}
+	if sc.MaxAttempts == 0 {
+		sc.MaxAttempts = DefaultSpoolMaxAttempts
+	}
+	return nil
+}
+
+// spool stores batches which could not be delivered as JSON files in a directory.
+type spool struct {
+	name           string
+	dir            string
+	maxBytes       int64
+	maxAge         time.Duration
+	replayInterval time.Duration
+	maxAttempts    int
+	mu             sync.Mutex
+	seq            int64
+	// failures counts retryable failures of replaying each file.
+	failures map[string]int
+}
+
+// newSpool creates a spool for the destination name. It returns nil when the spool is disabled.
+func newSpool(sc *SpoolConfig, name string) (*spool, error) {
+	if sc.Dir == "" {
+		return nil, nil
+	}
+	dir := filepath.Join(sc.Dir, name)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create spool directory: %w", err)
+	}
+	return &spool{
+		name:           name,
+		dir:            dir,
+		maxBytes:       sc.MaxBytes,
+		maxAge:         sc.MaxAge.Duration,
+		replayInterval: sc.ReplayInterval.Duration,
+		maxAttempts:    sc.MaxAttempts,
+		failures:       make(map[string]int),
+	}, nil
+}
+
+// Put stores v into the spool.
+func (sp *spool) Put(v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+
+	sp.seq++
+	name := fmt.Sprintf("%019d-%06d.json", time.Now().UnixNano(), sp.seq%1000000)
+	tmp := filepath.Join(sp.dir, "."+name)
+	if err := os.WriteFile(tmp, b, 0600); err != nil {
+		return fmt.Errorf("failed to write spool: %w", err)
+	}
+	if err := os.Rename(tmp, filepath.Join(sp.dir, name)); err != nil {
+		return fmt.Errorf("failed to write spool: %w", err)
+	}
+	sp.truncate()
+	return nil
+}
+
+// Replay calls fn with spooled batches from the oldest one.
+// A batch is removed when fn succeeds or returns a non-retryable error.
+// Replay stops at the first retryable error, because the destination seems to be unreachable yet.
+// But a file which failed maxAttempts times is skipped, and it is removed when a newer file is replayed,
+// so that a batch which never succeeds does not block newer batches.
+func (sp *spool) Replay(ctx context.Context, fn func([]byte) error) {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+
+	files := sp.files()
+	if len(files) == 0 {
+		return
+	}
+	var replayed int
+	var skipped []string
+	defer func() {
+		if replayed > 0 {
+			log.Printf("[spool] %s: replayed %d batches, %d batches remain", sp.name, replayed, len(sp.files()))
+		}
+	}()
+	for _, f := range files {
+		if ctx.Err() != nil {
+			return
+		}
+		if time.Since(f.modTime) > sp.maxAge {
+			log.Printf("[spool] %s: %s expired. removed", sp.name, f.name)
+			sp.remove(f.name)
+			continue
+		}
+		b, err := os.ReadFile(filepath.Join(sp.dir, f.name))
+		if err != nil {
+			log.Printf("[spool] %s: failed to read %s: %s", sp.name, f.name, err)
+			sp.remove(f.name)
+			continue
+		}
+		if err := fn(b); err != nil {
+			if isRetryableError(err) {
+				log.Printf("[spool] %s: replay failed: %s", sp.name, err)
+				sp.failures[f.name]++
+				if sp.failures[f.name] < sp.maxAttempts || len(skipped) > 0 {
+					return
+				}
+				skipped = append(skipped, f.name)
+				continue
+			}
+			log.Printf("[spool] %s: replay failed: %s. %s removed", sp.name, err, f.name)
+		} else {
+			replayed++
+			for _, name := range skipped {
+				log.Printf("[spool] %s: replay of %s failed %d times while newer batches succeeded. removed", sp.name, name, sp.failures[name])
+				sp.remove(name)
+			}
+			skipped = nil
+		}
+		sp.remove(f.name)
+	}
+}
+
+type spoolFile struct {
+	name    string
+	size    int64
+	modTime time.Time
+}
+
+// files returns spooled files ordered from the oldest.
+func (sp *spool) files() []spoolFile {
+	entries, err := os.ReadDir(sp.dir)
+	if err != nil {
+		log.Printf("[spool] %s: failed to read spool directory: %s", sp.name, err)
+		return nil
+	}
+	files := make([]spoolFile, 0, len(entries))
+	for _, e := range entries {
+		if e.IsDir() || strings.HasPrefix(e.Name(), ".") || !strings.HasSuffix(e.Name(), ".json") {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, spoolFile{name: e.Name(), size: info.Size(), modTime: info.ModTime()})
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].name < files[j].name
+	})
+	return files
+}
+
+// truncate removes the oldest files while the spool exceeds max_bytes.
+func (sp *spool) truncate() {
+	files := sp.files()
+	var total int64
+	for _, f := range files {
+		total += f.size
+	}
+	for _, f := range files {
+		if total <= sp.maxBytes {
+			return
+		}
+		log.Printf("[spool] %s: spool exceeds %d bytes. %s removed", sp.name, sp.maxBytes, f.name)
+		sp.remove(f.name)
+		total -= f.size
+	}
+}
+
+func (sp *spool) remove(name string) {
+	delete(sp.failures, name)
+	if err := os.Remove(filepath.Join(sp.dir, name)); err != nil && !os.IsNotExist(err) {
+		log.Printf("[spool] %s: failed to remove %s: %s", sp.name, name, err)
+	}
+}
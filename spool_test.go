@@ -0,0 +1,145 @@
+package sardine
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestSpool(t *testing.T) {
+	sc := &SpoolConfig{Dir: t.TempDir()}
+	if err := sc.setDefaults(); err != nil {
+		t.Fatal(err)
+	}
+	sp, err := newSpool(sc, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"a", "b", "c"} {
+		if err := sp.Put(ServiceMetric{Service: s}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// destination is unreachable
+	var replayed []string
+	replay := func(err error) func([]byte) error {
+		return func(b []byte) error {
+			replayed = append(replayed, string(b))
+			return err
+		}
+	}
+	sp.Replay(context.Background(), replay(errors.New("connection refused")))
+	if len(replayed) != 1 {
+		t.Errorf("replay must stop at the first retryable error: %v", replayed)
+	}
+	if n := len(sp.files()); n != 3 {
+		t.Errorf("unexpected spooled files expected:3 got:%d", n)
+	}
+
+	// destination is reachable again
+	replayed = nil
+	sp.Replay(context.Background(), replay(nil))
+	expected := []string{
+		`{"Service":"a","MetricValues":null}`,
+		`{"Service":"b","MetricValues":null}`,
+		`{"Service":"c","MetricValues":null}`,
+	}
+	if len(replayed) != len(expected) {
+		t.Fatalf("unexpected replayed expected:%v got:%v", expected, replayed)
+	}
+	for i := range expected {
+		if replayed[i] != expected[i] {
+			t.Errorf("unexpected replayed[%d] expected:%s got:%s", i, expected[i], replayed[i])
+		}
+	}
+	if n := len(sp.files()); n != 0 {
+		t.Errorf("unexpected spooled files expected:0 got:%d", n)
+	}
+}
+
+func TestSpoolMaxBytes(t *testing.T) {
+	sc := &SpoolConfig{Dir: t.TempDir(), MaxBytes: 100}
+	if err := sc.setDefaults(); err != nil {
+		t.Fatal(err)
+	}
+	sp, err := newSpool(sc, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		if err := sp.Put(ServiceMetric{Service: "test"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var total int64
+	for _, f := range sp.files() {
+		total += f.size
+	}
+	if total > 100 {
+		t.Errorf("spool must be truncated under max_bytes: %d", total)
+	}
+	if len(sp.files()) == 0 {
+		t.Error("spool must keep the newest files")
+	}
+}
+
+func TestSpoolReplayMaxAttempts(t *testing.T) {
+	sc := &SpoolConfig{Dir: t.TempDir(), MaxAttempts: 2}
+	if err := sc.setDefaults(); err != nil {
+		t.Fatal(err)
+	}
+	sp, err := newSpool(sc, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"poison", "b", "c"} {
+		if err := sp.Put(ServiceMetric{Service: s}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var replayed []string
+	replay := func(b []byte) error {
+		if strings.Contains(string(b), "poison") {
+			return errors.New("connection reset")
+		}
+		replayed = append(replayed, string(b))
+		return nil
+	}
+	// the first failure blocks newer files
+	sp.Replay(context.Background(), replay)
+	if len(replayed) != 0 || len(sp.files()) != 3 {
+		t.Errorf("replay must stop at the first failure: %v", replayed)
+	}
+	// the file failed max_attempts times is skipped, and removed after newer files are replayed
+	sp.Replay(context.Background(), replay)
+	if len(replayed) != 2 {
+		t.Errorf("newer files must be replayed: %v", replayed)
+	}
+	if n := len(sp.files()); n != 0 {
+		t.Errorf("unexpected spooled files expected:0 got:%d", n)
+	}
+}
+
+func TestSpoolReplayUnreachable(t *testing.T) {
+	sc := &SpoolConfig{Dir: t.TempDir(), MaxAttempts: 1}
+	if err := sc.setDefaults(); err != nil {
+		t.Fatal(err)
+	}
+	sp, err := newSpool(sc, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"a", "b", "c"} {
+		if err := sp.Put(ServiceMetric{Service: s}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 3; i++ {
+		sp.Replay(context.Background(), func([]byte) error { return errors.New("connection refused") })
+	}
+	if n := len(sp.files()); n != 3 {
+		t.Errorf("files must be kept while the destination is unreachable. got:%d", n)
+	}
+}
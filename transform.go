@@ -0,0 +1,142 @@
+package sardine
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"path"
+	"time"
+)
+
+// TransformRule transforms values of metrics.
+// A value becomes value * Scale / Divide + Offset, and then it is clamped into [Min, Max].
+type TransformRule struct {
+	Scale  *float64
+	Divide *float64
+	Offset *float64
+	Min    *float64
+	Max    *float64
+}
+
+func (r *TransformRule) apply(v float64) float64 {
+	if r.Scale != nil {
+		v *= *r.Scale
+	}
+	if r.Divide != nil {
+		v /= *r.Divide
+	}
+	if r.Offset != nil {
+		v += *r.Offset
+	}
+	if r.Min != nil && v < *r.Min {
+		v = *r.Min
+	}
+	if r.Max != nil && v > *r.Max {
+		v = *r.Max
+	}
+	return v
+}
+
+type namedMetricExpr struct {
+	name   string
+	source string
+	expr   metricExpr
+}
+
+// metricTransformer transforms values of metrics, and adds metrics calculated by expressions.
+type metricTransformer struct {
+	id    string
+	rules map[string]*TransformRule
+	exprs []*namedMetricExpr
+}
+
+func newMetricTransformer(id string, pc *PluginConfig) (*metricTransformer, error) {
+	if len(pc.Transform) == 0 && len(pc.Expressions) == 0 {
+		return nil, nil
+	}
+	t := &metricTransformer{
+		id:    id,
+		rules: make(map[string]*TransformRule, len(pc.Transform)),
+	}
+	for pattern, r := range pc.Transform {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern %s in transform: %w", pattern, err)
+		}
+		if r.Divide != nil && *r.Divide == 0 {
+			return nil, fmt.Errorf("divide of transform %s must not be zero", pattern)
+		}
+		if r.Min != nil && r.Max != nil && *r.Min > *r.Max {
+			return nil, fmt.Errorf("min of transform %s must not be greater than max", pattern)
+		}
+		t.rules[pattern] = r
+	}
+	for _, name := range sortedKeys(pc.Expressions) {
+		src := pc.Expressions[name]
+		e, err := parseMetricExpr(src)
+		if err != nil {
+			return nil, fmt.Errorf("invalid expression of %s: %w", name, err)
+		}
+		t.exprs = append(t.exprs, &namedMetricExpr{name: name, source: src, expr: e})
+	}
+	return t, nil
+}
+
+// apply returns transformed metrics and metrics calculated by expressions.
+// newMetric creates a metric named by the metric name in the command output.
+func (t *metricTransformer) apply(metrics []*Metric, newMetric func(name string) *Metric) []*Metric {
+	res := make([]*Metric, 0, len(metrics)+len(t.exprs))
+	for _, m := range metrics {
+		if r, ok := matchMetricPattern(t.rules, m); ok {
+			tm := *m
+			tm.Value = r.apply(m.Value)
+			m = &tm
+		}
+		res = append(res, m)
+	}
+	if len(t.exprs) == 0 {
+		return res
+	}
+
+	// metrics can be referred by the name in the command output, or the name without the namespace if it is unique.
+	byFullName := make(map[string]*Metric, len(res))
+	byName := make(map[string]*Metric, len(res))
+	for _, m := range res {
+		byFullName[m.fullName()] = m
+		if _, dup := byName[m.Name]; dup {
+			byName[m.Name] = nil
+		} else {
+			byName[m.Name] = m
+		}
+	}
+	for _, ne := range t.exprs {
+		var ts time.Time
+		v, err := ne.expr.eval(func(name string) (float64, bool) {
+			m, ok := byFullName[name]
+			if !ok {
+				m = byName[name]
+			}
+			if m == nil {
+				return 0, false
+			}
+			if m.Timestamp.After(ts) {
+				ts = m.Timestamp
+			}
+			return m.Value, true
+		})
+		if err == nil && (math.IsNaN(v) || math.IsInf(v, 0)) {
+			err = fmt.Errorf("result is not a finite number")
+		}
+		if err != nil {
+			log.Printf("[%s] failed to evaluate %s = %s: %s. skipped", t.id, ne.name, ne.source, err)
+			continue
+		}
+		if ts.IsZero() {
+			ts = time.Now()
+		}
+		m := newMetric(ne.name)
+		m.Value = v
+		m.Timestamp = ts
+		res = append(res, m)
+	}
+	return res
+}
@@ -0,0 +1,107 @@
+package sardine
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestMetricTransform(t *testing.T) {
+	conf, err := parseConfig([]byte(`
+[plugin.metrics.memcached]
+command = "true"
+
+[plugin.metrics.memcached.transform."*.bytes"]
+divide = 1048576
+
+[plugin.metrics.memcached.transform."memcached.stats.usage"]
+scale  = 100
+offset = -10
+min    = 0
+max    = 50
+
+[plugin.metrics.memcached.expressions]
+"memcached.stats.hit_ratio" = "get_hits / (get_hits + memcached.stats.get_misses)"
+"memcached.stats.doubled"   = "-2 * -bytes"
+"memcached.stats.missing"   = "get_hits + unknown"
+"memcached.stats.zero"      = "get_hits / (get_misses - 25)"
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mp := conf.MetricPlugins["memcached"].(*CommandMetricPlugin)
+	var metrics []*Metric
+	for _, line := range []string{
+		"memcached.stats.bytes\t2097152\t1512057958",
+		"memcached.stats.usage\t0.3\t1512057958",
+		"memcached.stats.get_hits\t75\t1512057958",
+		"memcached.stats.get_misses\t25\t1512057960",
+	} {
+		m, err := mp.ParseMetricLine(line)
+		if err != nil {
+			t.Fatal(err)
+		}
+		metrics = append(metrics, m)
+	}
+	res := mp.transformer.apply(metrics, mp.newMetric)
+	expected := map[string]float64{
+		"bytes":      2,
+		"usage":      20,
+		"get_hits":   75,
+		"get_misses": 25,
+		"hit_ratio":  0.75,
+		"doubled":    4,
+	}
+	if len(res) != len(expected) {
+		t.Fatalf("unexpected metrics %d expected:%d", len(res), len(expected))
+	}
+	for _, m := range res {
+		if m.Namespace != "memcached/stats" {
+			t.Errorf("unexpected namespace of %s: %s", m.Name, m.Namespace)
+		}
+		if v, ok := expected[m.Name]; !ok || math.Abs(m.Value-v) > 1e-9 {
+			t.Errorf("unexpected value of %s: %g expected:%g", m.Name, m.Value, v)
+		}
+		if m.Name == "hit_ratio" && !m.Timestamp.Equal(time.Unix(1512057960, 0)) {
+			t.Errorf("unexpected timestamp of hit_ratio: %s", m.Timestamp)
+		}
+	}
+	if metrics[0].Value != 2097152 {
+		t.Errorf("original metric must not be modified: %g", metrics[0].Value)
+	}
+}
+
+func TestParseMetricExpr(t *testing.T) {
+	values := map[string]float64{"a": 3, "b.c": 4, "d_e1": 2}
+	lookup := func(name string) (float64, bool) {
+		v, ok := values[name]
+		return v, ok
+	}
+	tests := []struct {
+		expr  string
+		value float64
+	}{
+		{"1 + 2 * 3", 7},
+		{"(1 + 2) * 3", 9},
+		{"a * b.c - d_e1", 10},
+		{"-a + 1.5e1", 12},
+		{"b.c/d_e1/2", 1},
+		{"10 - 4 - 3", 3},
+	}
+	for _, tt := range tests {
+		e, err := parseMetricExpr(tt.expr)
+		if err != nil {
+			t.Errorf("failed to parse %s: %s", tt.expr, err)
+			continue
+		}
+		v, err := e.eval(lookup)
+		if err != nil || v != tt.value {
+			t.Errorf("unexpected result of %s: %g %v expected:%g", tt.expr, v, err, tt.value)
+		}
+	}
+	for _, s := range []string{"", "1 +", "(a", "a b", "a % b", "1..2"} {
+		if _, err := parseMetricExpr(s); err == nil {
+			t.Errorf("expression %q must be invalid", s)
+		}
+	}
+}
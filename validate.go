@@ -0,0 +1,110 @@
+package sardine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	config "github.com/kayac/go-config"
+)
+
+// ValidateConfig validates the config and returns all errors found in it as ConfigErrors.
+// In addition to LoadConfig, it checks that commands are found in $PATH and that no unknown keys exist.
+func ValidateConfig(ctx context.Context, path string) error {
+	configBytes, err := loadURL(ctx, path)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	var errs ConfigErrors
+	b, err := config.ReadWithEnvBytes(configBytes)
+	if err != nil {
+		return err
+	}
+	md, err := toml.Decode(string(b), &Config{})
+	if err != nil {
+		return err
+	}
+	for _, key := range md.Undecoded() {
+		errs = append(errs, fmt.Errorf("unknown key %s", key))
+	}
+	errs = append(errs, misplacedKeyErrors(md)...)
+
+	c, err := parseConfig(configBytes)
+	if err != nil {
+		var cerrs ConfigErrors
+		if !errors.As(err, &cerrs) {
+			return err
+		}
+		errs = append(errs, cerrs...)
+	}
+	for _, id := range sortedKeys(c.MetricPlugins) {
+		mp := c.MetricPlugins[id]
+		if err := lookPath(mp.Command()); err != nil {
+			errs = append(errs, fmt.Errorf("[%s] %w", mp.ID(), err))
+		}
+	}
+	for _, id := range sortedKeys(c.CheckPlugins) {
+		cp := c.CheckPlugins[id]
+		if cp.checker != nil {
+			continue
+		}
+		if err := lookPath(cp.Command); err != nil {
+			errs = append(errs, fmt.Errorf("[%s] %w", cp.ID, err))
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// pluginKindKeys are keys of PluginConfig which are available only for the kind of plugins.
+// PluginConfig is shared by metric and check plugins, so misplaced keys are decoded and ignored silently.
+var pluginKindKeys = map[string]map[string]bool{
+	"metrics": {
+		"destinations": true, "destination_options": true, "service": true, "aggregation_window": true,
+		"unit": true, "units": true, "rate": true, "include": true, "exclude": true,
+		"namespace_prefix": true, "namespace_segments": true, "rewrite": true,
+		"extract_dimensions": true, "transform": true, "expressions": true, "plugin_meta": true,
+	},
+	"check": {
+		"host_id": true, "max_check_attempts": true, "retry_interval": true, "all_states": true,
+		"type": true, "address": true, "send": true, "expect": true, "url": true, "status": true,
+		"cert_expiry_warning": true, "cert_expiry_critical": true, "insecure": true,
+		"host": true, "server": true, "process": true, "pidfile": true,
+	},
+}
+
+// misplacedKeyErrors returns errors of keys in [plugin.{kind}.*] which are available only for another kind of plugins.
+func misplacedKeyErrors(md toml.MetaData) []error {
+	var errs []error
+	for _, key := range md.Keys() {
+		if len(key) != 4 || key[0] != "plugin" {
+			continue
+		}
+		kind, name := key[1], strings.ToLower(key[3])
+		if _, ok := pluginKindKeys[kind]; !ok {
+			continue
+		}
+		for _, other := range sortedKeys(pluginKindKeys) {
+			if other != kind && pluginKindKeys[other][name] {
+				errs = append(errs, fmt.Errorf("key %s is available only in [plugin.%s.*]", key, other))
+			}
+		}
+	}
+	return errs
+}
+
+func lookPath(command []string) error {
+	if len(command) == 0 {
+		return fmt.Errorf("command required")
+	}
+	if _, err := exec.LookPath(command[0]); err != nil {
+		return fmt.Errorf("command %s is not found: %w", command[0], err)
+	}
+	return nil
+}